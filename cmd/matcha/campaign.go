@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"matcha/internal/exploitable"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+const stateSaveInterval = time.Minute
+
+// CampaignState is what a restarted campaign needs to carry on where it stopped. Blocks are
+// offsets from the base address like in the blocks file. The generator can't be serialized
+// so every save reseeds it from itself and keeps that seed, a resumed run draws the same
+// numbers the interrupted one would have. Flags are the command line flags deciding how the
+// target is run, so the tools given the state file run inputs the way the campaign did
+type CampaignState struct {
+	Target      string            `json:"target"`
+	Flags       map[string]string `json:"flags"`
+	HitBlocks   []uint64          `json:"hit_blocks"`
+	FuzzCases   uint64            `json:"fuzz_cases"`
+	Crashes     uint64            `json:"crashes"`
+	Hangs       uint64            `json:"hangs"`
+	Elapsed     float64           `json:"elapsed_seconds"`
+	RandSeed    int64             `json:"rand_seed"`
+	CrashHashes []string          `json:"crash_hashes"`
+	// CrashBuckets count the crashes of every bucket, CrashRatings hold their ratings
+	CrashBuckets map[string]uint64             `json:"crash_buckets"`
+	CrashRatings map[string]exploitable.Rating `json:"crash_ratings"`
+}
+
+// hitBlocks are the offsets of the blocks hit so far
+func (s *State) hitBlocks() []uint64 {
+	hit := s.Coverage.HitBlocks()
+	for i := range hit {
+		hit[i] -= s.BaseAddress
+	}
+	return hit
+}
+
+func (s *State) SaveState() error {
+	if s.StateFile == "" {
+		return nil
+	}
+	seed := rand.Int63()
+	rand.Seed(seed)
+	campaign := CampaignState{
+		Target:       s.Path,
+		Flags:        runFlags(flag.CommandLine),
+		HitBlocks:    s.hitBlocks(),
+		FuzzCases:    s.FuzzCases,
+		Crashes:      s.Crashes,
+		Hangs:        s.Hangs,
+		Elapsed:      time.Since(START_TIME).Seconds(),
+		RandSeed:     seed,
+		CrashHashes:  make([]string, 0, len(s.Corpus.CrashHashes)),
+		CrashBuckets: s.CrashBuckets,
+		CrashRatings: s.CrashRatings,
+	}
+	for hash := range s.Corpus.CrashHashes {
+		campaign.CrashHashes = append(campaign.CrashHashes, hash)
+	}
+	sort.Strings(campaign.CrashHashes)
+	data, err := json.MarshalIndent(campaign, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write then rename so an interrupted save never leaves half a file behind
+	tmp := s.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.StateFile); err != nil {
+		return err
+	}
+	s.LastStateSave = time.Now()
+	return nil
+}
+
+// SaveStatePeriodically saves the state file once stateSaveInterval has passed since the
+// last save. A failed save only costs the progress since the previous one so it is reported
+// and the campaign carries on
+func (s *State) SaveStatePeriodically() {
+	if time.Since(s.LastStateSave) < stateSaveInterval {
+		return
+	}
+	if err := s.SaveState(); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: saving state: %v\n", err)
+		s.LastStateSave = time.Now()
+	}
+}
+
+func LoadCampaignState(path string) (CampaignState, error) {
+	var campaign CampaignState
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return campaign, err
+	}
+	if err := json.Unmarshal(data, &campaign); err != nil {
+		return campaign, fmt.Errorf("bad state file %s: %w", path, err)
+	}
+	return campaign, nil
+}
+
+// ResumeState loads the state file, must be called once the breakpoint addresses are
+// known and before the target is instrumented
+func (s *State) ResumeState() error {
+	campaign, err := LoadCampaignState(s.StateFile)
+	if err != nil {
+		return err
+	}
+	if campaign.Target != s.Path {
+		return fmt.Errorf("state file %s is for %s not %s", s.StateFile, campaign.Target, s.Path)
+	}
+	s.Coverage.Skip = make(map[uint64]bool)
+	for _, offset := range campaign.HitBlocks {
+		s.Coverage.Skip[s.BaseAddress+offset] = true
+	}
+	s.Coverage.Hit = 0
+	for _, address := range s.Coverage.Addresses {
+		if s.Coverage.Skip[address] {
+			s.Coverage.Hit++
+		}
+	}
+	s.FuzzCases = campaign.FuzzCases
+	s.Crashes = campaign.Crashes
+	s.Hangs = campaign.Hangs
+	START_TIME = time.Now().Add(-time.Duration(campaign.Elapsed * float64(time.Second)))
+	rand.Seed(campaign.RandSeed)
+	s.Corpus.CrashHashes = make(map[string]bool)
+	for _, hash := range campaign.CrashHashes {
+		s.Corpus.CrashHashes[hash] = true
+	}
+	for bucket, count := range campaign.CrashBuckets {
+		s.CrashBuckets[bucket] = count
+		s.CrashRatings[bucket] = campaign.CrashRatings[bucket]
+	}
+	s.LastStateSave = time.Now()
+	fmt.Printf("Resumed %d Iterations %d Crashes %d/%d Blocks Hit From %s\n", s.FuzzCases, s.Crashes, s.Coverage.Hit, len(s.Coverage.Addresses), s.StateFile)
+	return nil
+}
+
+// runFlagNames are the fuzzer flags that decide how the target is run, those a replay of a
+// crash needs
+var runFlagNames = []string{
+	"mode", "target", "target-args", "base", "blocks", "snapshot-at", "restore-at", "snapshot-file",
+	"virtual-file", "network", "framed", "loopback", "loopback-port", "loopback-delay",
+	"loopback-timeout", "sequence", "inject", "inject-max", "inject-scratch", "timeout",
+}
+
+// runFlags are the values of the run flags of fs, defaults included
+func runFlags(fs *flag.FlagSet) map[string]string {
+	flags := make(map[string]string)
+	for _, name := range runFlagNames {
+		if f := fs.Lookup(name); f != nil {
+			flags[name] = f.Value.String()
+		}
+	}
+	return flags
+}
+
+// ApplyCampaignFlags sets the flags of fs the campaign saved in stateFile was run with.
+// Flags given on the command line win, and state files from before flags were saved only
+// give the target
+func ApplyCampaignFlags(fs *flag.FlagSet, stateFile string) error {
+	campaign, err := LoadCampaignState(stateFile)
+	if err != nil {
+		return err
+	}
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	flags := campaign.Flags
+	if flags == nil {
+		flags = map[string]string{"target": campaign.Target}
+	}
+	for name, value := range flags {
+		if fs.Lookup(name) == nil || given[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("state file %s: -%s %s: %w", stateFile, name, value, err)
+		}
+	}
+	return nil
+}
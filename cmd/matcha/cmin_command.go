@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+)
+
+type cminEntry struct {
+	Name   string
+	Data   []byte
+	Blocks []uint64
+}
+
+// CminCommand copies the smallest set of inputs it can find that keeps the coverage of the
+// whole corpus. Every input runs once with all breakpoints set, then inputs adding the most
+// uncovered blocks are picked one at a time, smaller files winning ties
+//
+//	matcha cmin -i corpus -o corpus.min -target ./jsonlint -blocks ./libjson_blocks.txt
+func CminCommand(args []string) {
+	fs := flag.NewFlagSet("cmin", flag.ExitOnError)
+	inputPtr := fs.String("i", "", "corpus directory to minimize")
+	outputPtr := fs.String("o", "", "directory to copy the minimized corpus to")
+	options := addTargetFlags(fs)
+	if err := options.Parse(fs, args); err != nil {
+		log.Fatal(err)
+	}
+	if *inputPtr == "" || *outputPtr == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := os.MkdirAll(*outputPtr, 0755); err != nil {
+		log.Fatal(err)
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	s, err := options.NewTracingState()
+	if err != nil {
+		log.Fatal(err)
+	}
+	entries, err := s.traceCorpus(*inputPtr, s.PayloadPath(*outputPtr))
+	if err != nil {
+		log.Fatal(err)
+	}
+	picked := MinimizeCorpus(entries)
+	for _, entry := range picked {
+		err := os.WriteFile(filepath.Join(*outputPtr, entry.Name), entry.Data, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	fmt.Printf("INFO: Kept %d/%d inputs covering %d/%d blocks\n", len(picked), len(entries), coveredBlocks(entries), len(s.Coverage.Addresses))
+}
+
+// traceCorpus runs every input of dir once and returns them with the blocks they hit
+func (s *State) traceCorpus(dir string, payloadPath string) ([]cminEntry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if s.VirtualFile == nil {
+		defer os.Remove(payloadPath)
+	}
+	entries := make([]cminEntry, 0)
+	for _, e := range files {
+		if e.IsDir() || e.Name() == "tmp.bin" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		result, err := s.TraceCase(data, payloadPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		entries = append(entries, cminEntry{Name: e.Name(), Data: data, Blocks: result.Blocks})
+	}
+	return entries, nil
+}
+
+// coveredBlocks counts the blocks hit by any of entries
+func coveredBlocks(entries []cminEntry) int {
+	covered := make(map[uint64]bool)
+	for _, entry := range entries {
+		for _, block := range entry.Blocks {
+			covered[block] = true
+		}
+	}
+	return len(covered)
+}
+
+// MinimizeCorpus greedily picks entries until the union of their blocks is the union of
+// every entry's blocks, entries hitting nothing new are left out. entries is not reordered
+func MinimizeCorpus(entries []cminEntry) []cminEntry {
+	entries = slices.Clone(entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return len(entries[i].Data) < len(entries[j].Data)
+	})
+	covered := make(map[uint64]bool)
+	used := make([]bool, len(entries))
+	picked := make([]cminEntry, 0)
+	for {
+		best, bestNew := -1, 0
+		for i, entry := range entries {
+			if used[i] {
+				continue
+			}
+			added := 0
+			for _, block := range entry.Blocks {
+				if !covered[block] {
+					added++
+				}
+			}
+			// entries are sorted by size so the first one with the most new blocks is the smallest
+			if added > bestNew {
+				best, bestNew = i, added
+			}
+		}
+		if best < 0 {
+			return picked
+		}
+		used[best] = true
+		for _, block := range entries[best].Blocks {
+			covered[block] = true
+		}
+		picked = append(picked, entries[best])
+	}
+}
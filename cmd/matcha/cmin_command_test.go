@@ -0,0 +1,47 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMinimizeCorpus(t *testing.T) {
+	entry := func(name string, size int, blocks ...uint64) cminEntry {
+		return cminEntry{Name: name, Data: make([]byte, size), Blocks: blocks}
+	}
+	tests := []struct {
+		name    string
+		entries []cminEntry
+		want    []string
+	}{
+		{"empty corpus", nil, nil},
+		{"no coverage", []cminEntry{entry("a", 1), entry("b", 2)}, nil},
+		{"subset left out", []cminEntry{entry("a", 4, 1, 2, 3), entry("b", 1, 2)}, []string{"a"}},
+		{"smaller wins a tie", []cminEntry{entry("big", 9, 1, 2), entry("small", 3, 1, 2)}, []string{"small"}},
+		{"same size keeps the corpus order", []cminEntry{entry("a", 3, 1), entry("b", 3, 1)}, []string{"a"}},
+		// b adds the most first, then a and c only cover 1 and 4 between them
+		{"overlapping", []cminEntry{entry("a", 1, 1, 2), entry("b", 2, 2, 3, 5), entry("c", 3, 3, 4)}, []string{"b", "a", "c"}},
+		{"one entry covers all", []cminEntry{entry("a", 1, 1), entry("b", 2, 2), entry("all", 5, 1, 2, 3)}, []string{"all"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := slices.Clone(test.entries)
+			picked := MinimizeCorpus(test.entries)
+			var got []string
+			for _, entry := range picked {
+				got = append(got, entry.Name)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("MinimizeCorpus = %v, want %v", got, test.want)
+			}
+			if covered, want := coveredBlocks(picked), coveredBlocks(test.entries); covered != want {
+				t.Errorf("picked entries cover %d blocks, want %d", covered, want)
+			}
+			for i := range original {
+				if test.entries[i].Name != original[i].Name {
+					t.Fatal("MinimizeCorpus reordered its entries")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// upper bounds of the exec latency histogram buckets in seconds, +Inf is implied
+var latencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// biggest seed accepted by the seed endpoint
+const maxSeedSize = 1 << 20
+
+// how often a paused loop looks for a stop signal
+const pausePoll = 100 * time.Millisecond
+
+// ControlServer is the HTTP API of a running campaign. The fuzz loop owns the State, so
+// handlers only read the stats it publishes between cases and queue seeds for it to pick up
+// in Poll
+type ControlServer struct {
+	server *http.Server
+	seeds  chan []byte
+
+	mu            sync.Mutex
+	status        ControlStatus
+	latencyCounts []uint64
+	latencySum    float64
+	latencyCount  uint64
+	paused        bool
+}
+
+// ControlStatus is the /status document
+type ControlStatus struct {
+	FuzzerStats
+	Paused bool `json:"paused"`
+}
+
+// StartControlServer listens on address and serves the API in the background:
+//
+//	GET  /metrics  Prometheus text format
+//	GET  /status   ControlStatus as JSON
+//	POST /pause    stop running cases until resumed
+//	POST /resume
+//	POST /stop     stop the campaign like SIGINT does
+//	POST /seed     add the request body to the corpus
+func StartControlServer(address string) (*ControlServer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	c := &ControlServer{
+		seeds:         make(chan []byte, 16),
+		latencyCounts: make([]uint64, len(latencyBuckets)+1),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", c.handleMetrics)
+	mux.HandleFunc("/status", c.handleStatus)
+	mux.HandleFunc("/pause", c.handlePause(true))
+	mux.HandleFunc("/resume", c.handlePause(false))
+	mux.HandleFunc("/stop", c.handleStop)
+	mux.HandleFunc("/seed", c.handleSeed)
+	c.server = &http.Server{Handler: mux}
+	go func() {
+		if err := c.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "WARNING: control server stopped: %v\n", err)
+		}
+	}()
+	fmt.Printf("Control Server Listening On http://%s\n", listener.Addr())
+	return c, nil
+}
+
+func (c *ControlServer) Close() error {
+	return c.server.Close()
+}
+
+// ObserveExec adds the duration of one run to the latency histogram
+func (c *ControlServer) ObserveExec(d time.Duration) {
+	seconds := d.Seconds()
+	bucket := len(latencyBuckets)
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			bucket = i
+			break
+		}
+	}
+	c.mu.Lock()
+	c.latencyCounts[bucket]++
+	c.latencySum += seconds
+	c.latencyCount++
+	c.mu.Unlock()
+}
+
+// Poll is called by the fuzz loop between cases. It adds queued seeds to the corpus,
+// publishes the stats and blocks while the campaign is paused
+func (c *ControlServer) Poll(s *State) error {
+	for {
+		select {
+		case seed := <-c.seeds:
+			if err := s.Corpus.AddToCorpus(seed); err != nil {
+				return err
+			}
+		default:
+			c.publish(s)
+			if !c.Paused() || StopRequested() {
+				return nil
+			}
+			if s.Dashboard != nil {
+				s.Dashboard.DrawPeriodically(s)
+			}
+			time.Sleep(pausePoll)
+		}
+	}
+}
+
+func (c *ControlServer) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+func (c *ControlServer) publish(s *State) {
+	stats := s.Stats()
+	c.mu.Lock()
+	c.status.FuzzerStats = stats
+	c.mu.Unlock()
+}
+
+func (c *ControlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	status := c.status
+	status.Paused = c.paused
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (c *ControlServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	stats := c.status.FuzzerStats
+	paused := c.paused
+	counts := append([]uint64(nil), c.latencyCounts...)
+	sum, count := c.latencySum, c.latencyCount
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metric := func(name string, kind string, help string, value string) {
+		fmt.Fprintf(w, "# HELP matcha_%s %s\n# TYPE matcha_%s %s\nmatcha_%s %s\n", name, help, name, kind, name, value)
+	}
+	metric("execs_total", "counter", "Cases run.", strconv.FormatUint(stats.Execs, 10))
+	metric("coverage_blocks", "gauge", "Basic blocks hit.", strconv.FormatUint(stats.BlocksHit, 10))
+	metric("coverage_blocks_instrumented", "gauge", "Basic blocks in the blocks file.", strconv.FormatUint(stats.BlocksTotal, 10))
+	metric("crashes_unique", "gauge", "Crash buckets, crashes told apart by sanitizer error or signal and pc.", strconv.Itoa(stats.UniqueCrashes))
+	metric("crashes_saved", "gauge", "Crashing inputs with distinct contents saved.", strconv.Itoa(stats.SavedCrashes))
+	metric("crashes_total", "counter", "Cases that crashed the target.", strconv.FormatUint(stats.TotalCrashes, 10))
+	metric("hangs_total", "counter", "Cases stopped by the timeout.", strconv.FormatUint(stats.Hangs, 10))
+	metric("corpus_size", "gauge", "Entries in the corpus.", strconv.Itoa(stats.CorpusSize))
+	metric("uptime_seconds", "gauge", "Time the campaign has been running.", strconv.FormatFloat(stats.Uptime, 'f', -1, 64))
+	pausedValue := "0"
+	if paused {
+		pausedValue = "1"
+	}
+	metric("paused", "gauge", "1 while the fuzz loop is paused.", pausedValue)
+	fmt.Fprintf(w, "# HELP matcha_exec_latency_seconds Time taken to run a case.\n# TYPE matcha_exec_latency_seconds histogram\n")
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "matcha_exec_latency_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(bound, 'f', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "matcha_exec_latency_seconds_bucket{le=\"+Inf\"} %d\n", count)
+	fmt.Fprintf(w, "matcha_exec_latency_seconds_sum %s\n", strconv.FormatFloat(sum, 'f', -1, 64))
+	fmt.Fprintf(w, "matcha_exec_latency_seconds_count %d\n", count)
+}
+
+func (c *ControlServer) handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST only", http.StatusMethodNotAllowed)
+			return
+		}
+		c.mu.Lock()
+		c.paused = paused
+		c.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (c *ControlServer) handleStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	stopRequested.Store(true)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (c *ControlServer) handleSeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	seed, err := io.ReadAll(io.LimitReader(r.Body, maxSeedSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(seed) == 0 || len(seed) > maxSeedSize {
+		http.Error(w, fmt.Sprintf("seed must be 1 to %d bytes", maxSeedSize), http.StatusBadRequest)
+		return
+	}
+	select {
+	case c.seeds <- seed:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "too many seeds queued", http.StatusServiceUnavailable)
+	}
+}
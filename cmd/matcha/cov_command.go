@@ -0,0 +1,256 @@
+package main
+
+import (
+	"debug/elf"
+	"flag"
+	"fmt"
+	"log"
+	"matcha/fuzzer/coverage"
+	"matcha/internal/symbols"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+func covUsage() {
+	fmt.Fprintln(os.Stderr, "usage: matcha cov export -format drcov|lcov -o <file> [-state <file>] [-i <corpus>] [target flags]")
+	fmt.Fprintln(os.Stderr, "       matcha cov report -o <file.html> [-state <file>] [-i <corpus>] [-baseline <state file>] [target flags]")
+	os.Exit(2)
+}
+
+func CovCommand(args []string) {
+	if len(args) < 1 {
+		covUsage()
+	}
+	switch args[0] {
+	case "export":
+		CovExportCommand(args[1:])
+	case "report":
+		CovReportCommand(args[1:])
+	default:
+		covUsage()
+	}
+}
+
+// CovExportCommand writes the blocks hit by a campaign or a corpus for other tools
+//
+//	matcha cov export -format drcov -o vpxdec.drcov -state ./matcha.state
+//	matcha cov export -format lcov -o exif.info -i ./corpus -target ./exif -blocks ./exif_blocks.txt
+func CovExportCommand(args []string) {
+	fs := flag.NewFlagSet("cov export", flag.ExitOnError)
+	formatPtr := fs.String("format", "drcov", "drcov for Lighthouse, Cartographer and bncov, or lcov from DWARF line info")
+	outputPtr := fs.String("o", "", "file to write the coverage to")
+	sources := addCoverageSourceFlags(fs)
+	if err := sources.options.Parse(fs, args); err != nil {
+		log.Fatal(err)
+	}
+	if *outputPtr == "" {
+		covUsage()
+	}
+	image, err := LoadCoverageImage(*sources.options.target)
+	if err != nil {
+		log.Fatal(err)
+	}
+	blocks, err := sources.Collect(image)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out, err := os.Create(*outputPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+	switch *formatPtr {
+	case "drcov":
+		var path string
+		if path, err = filepath.Abs(*sources.options.target); err == nil {
+			err = coverage.WriteDrcov(out, coverage.Module{Path: path, Base: *sources.options.base, Size: image.Size}, blocks)
+		}
+	case "lcov":
+		var lines map[string]map[int]uint64
+		if lines, err = image.LineHits(blocks); err == nil {
+			err = coverage.WriteLcov(out, filepath.Base(*sources.options.target), lines)
+		}
+	default:
+		err = fmt.Errorf("unknown format %s", *formatPtr)
+	}
+	if err != nil {
+		os.Remove(*outputPtr)
+		log.Fatal(err)
+	}
+	fmt.Printf("INFO: Wrote %d/%d hit blocks to %s\n", hitCount(blocks), len(blocks), *outputPtr)
+}
+
+func hitCount(blocks []coverage.Block) int {
+	hit := 0
+	for _, block := range blocks {
+		if block.Hits > 0 {
+			hit++
+		}
+	}
+	return hit
+}
+
+// coverageSources are where the coverage tools get hit blocks from, a saved campaign, a
+// corpus traced once per input, or both
+type coverageSources struct {
+	corpus  *string
+	options *targetOptions
+}
+
+func addCoverageSourceFlags(fs *flag.FlagSet) *coverageSources {
+	return &coverageSources{
+		corpus:  fs.String("i", "", "corpus directory, every input is run once and the blocks it hits exported"),
+		options: addTargetFlags(fs),
+	}
+}
+
+// Collect sizes every block of the blocks file and counts the inputs that hit it, blocks
+// from a state file count one hit
+func (c *coverageSources) Collect(image *CoverageImage) ([]coverage.Block, error) {
+	if *c.options.state == "" && *c.corpus == "" {
+		return nil, fmt.Errorf("need a -state file or an -i corpus directory")
+	}
+	offsets, err := coverage.LoadBlocks(*c.options.blocks, 0)
+	if err != nil {
+		return nil, err
+	}
+	hits := make(map[uint64]uint64)
+	if *c.options.state != "" {
+		if err := c.stateHits(*c.options.state, hits); err != nil {
+			return nil, err
+		}
+	}
+	if *c.corpus != "" {
+		if err := c.traceCorpus(hits); err != nil {
+			return nil, err
+		}
+	}
+	return coverage.SizeBlocks(offsets, hits, image.FunctionEnd), nil
+}
+
+// CollectState is Collect for a state file other than the -state one
+func (c *coverageSources) CollectState(image *CoverageImage, stateFile string) ([]coverage.Block, error) {
+	offsets, err := coverage.LoadBlocks(*c.options.blocks, 0)
+	if err != nil {
+		return nil, err
+	}
+	hits := make(map[uint64]uint64)
+	if err := c.stateHits(stateFile, hits); err != nil {
+		return nil, err
+	}
+	return coverage.SizeBlocks(offsets, hits, image.FunctionEnd), nil
+}
+
+func (c *coverageSources) stateHits(stateFile string, hits map[uint64]uint64) error {
+	campaign, err := LoadCampaignState(stateFile)
+	if err != nil {
+		return err
+	}
+	if campaign.Target != *c.options.target {
+		return fmt.Errorf("state file %s is for %s not %s", stateFile, campaign.Target, *c.options.target)
+	}
+	for _, offset := range campaign.HitBlocks {
+		hits[offset]++
+	}
+	return nil
+}
+
+func (c *coverageSources) traceCorpus(hits map[uint64]uint64) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	s, err := c.options.NewTracingState()
+	if err != nil {
+		return err
+	}
+	payloadPath := s.PayloadPath(os.TempDir())
+	if s.VirtualFile == nil {
+		defer os.Remove(payloadPath)
+	}
+	dir, err := os.ReadDir(*c.corpus)
+	if err != nil {
+		return err
+	}
+	for _, e := range dir {
+		if e.IsDir() || e.Name() == "tmp.bin" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(*c.corpus, e.Name()))
+		if err != nil {
+			return err
+		}
+		result, err := s.TraceCase(data, payloadPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		for _, address := range result.Blocks {
+			hits[address-s.BaseAddress]++
+		}
+	}
+	return nil
+}
+
+// CoverageImage is what the coverage tools know about the target binary. Blocks are offsets
+// from Start, the link time address of the image
+type CoverageImage struct {
+	Start     uint64
+	Size      uint64
+	Functions []symbols.Function
+	// Lines is nil when the binary has no DWARF line info
+	Lines    []symbols.Line
+	LinesErr error
+}
+
+func LoadCoverageImage(path string) (*CoverageImage, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	image := &CoverageImage{Functions: symbols.Functions(f)}
+	image.Start, image.Size = symbols.ImageRange(f)
+	image.Lines, image.LinesErr = symbols.LineTable(f)
+	return image, nil
+}
+
+// FunctionEnd is the offset the function containing offset ends at, 0 outside functions
+func (image *CoverageImage) FunctionEnd(offset uint64) uint64 {
+	function := symbols.FunctionAt(image.Functions, image.Start+offset)
+	if function == nil {
+		return 0
+	}
+	return function.Address + function.Size - image.Start
+}
+
+// Describe names the function and source line of the code at offset, as far as they are known
+func (image *CoverageImage) Describe(offset uint64) string {
+	address := image.Start + offset
+	description := ""
+	if function := symbols.FunctionAt(image.Functions, address); function != nil {
+		description = fmt.Sprintf("%s+0x%x", function.Name, address-function.Address)
+	}
+	if lines := symbols.LinesIn(image.Lines, address, address+1); len(lines) > 0 {
+		description += fmt.Sprintf(" %s:%d", filepath.Base(lines[0].File), lines[0].Line)
+	}
+	return strings.TrimSpace(description)
+}
+
+// LineHits maps the source lines of every block to the hits of the most hit block sharing
+// the line, lines only in blocks never hit are 0
+func (image *CoverageImage) LineHits(blocks []coverage.Block) (map[string]map[int]uint64, error) {
+	if image.Lines == nil {
+		return nil, image.LinesErr
+	}
+	lines := make(map[string]map[int]uint64)
+	for _, block := range blocks {
+		start := image.Start + block.Offset
+		for _, line := range symbols.LinesIn(image.Lines, start, start+block.Size) {
+			if lines[line.File] == nil {
+				lines[line.File] = make(map[int]uint64)
+			}
+			lines[line.File][line.Line] = max(lines[line.File][line.Line], block.Hits)
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"matcha/fuzzer/coverage"
+	"matcha/internal/symbols"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// CoverageReport is the data behind the HTML report. Everything is sorted by address, path
+// or line so two reports of the same target diff cleanly
+type CoverageReport struct {
+	Target    string
+	Baseline  string
+	Blocks    coverageCount
+	Functions []functionCoverage
+	Files     []fileCoverage
+	// Reached counts functions with a hit block
+	Reached int
+	// NoLines is why there is no per file breakdown, empty when there is one
+	NoLines string
+}
+
+// coverageCount is hit out of total, New and Lost are against the baseline
+type coverageCount struct {
+	Hit   int
+	Total int
+	New   int
+	Lost  int
+}
+
+func (c coverageCount) Percent() string {
+	if c.Total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(c.Hit)/float64(c.Total)*100)
+}
+
+type functionCoverage struct {
+	Name    string
+	Address uint64
+	Blocks  coverageCount
+}
+
+type fileCoverage struct {
+	Path  string
+	Lines coverageCount
+	Rows  []lineCoverage
+}
+
+type lineCoverage struct {
+	Number int
+	Hits   uint64
+	Source string
+	New    bool
+	Lost   bool
+}
+
+// CovReportCommand writes a static HTML coverage report grouped by function and source
+// file. With a baseline state file blocks and lines hit since or no longer hit are marked
+//
+//	matcha cov report -o exif.html -state ./matcha.state -baseline ./old.state -target ./exif -blocks ./exif_blocks.txt
+func CovReportCommand(args []string) {
+	fs := flag.NewFlagSet("cov report", flag.ExitOnError)
+	outputPtr := fs.String("o", "", "html file to write the report to")
+	baselinePtr := fs.String("baseline", "", "state file of an earlier campaign to compare against")
+	sources := addCoverageSourceFlags(fs)
+	if err := sources.options.Parse(fs, args); err != nil {
+		log.Fatal(err)
+	}
+	if *outputPtr == "" {
+		covUsage()
+	}
+	image, err := LoadCoverageImage(*sources.options.target)
+	if err != nil {
+		log.Fatal(err)
+	}
+	blocks, err := sources.Collect(image)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var baseline []coverage.Block
+	if *baselinePtr != "" {
+		if baseline, err = sources.CollectState(image, *baselinePtr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	report := BuildCoverageReport(image, blocks, baseline)
+	report.Target = *sources.options.target
+	report.Baseline = *baselinePtr
+	out, err := os.Create(*outputPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	if err := reportTemplate.Execute(w, report); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("INFO: Wrote report of %d/%d hit blocks in %d/%d reached functions to %s\n", report.Blocks.Hit, report.Blocks.Total, report.Reached, len(report.Functions), *outputPtr)
+}
+
+// BuildCoverageReport groups blocks by the function symbol they are in, blocks outside any
+// function go under "(no symbol)". baseline are the same blocks hit by an earlier run or nil
+func BuildCoverageReport(image *CoverageImage, blocks []coverage.Block, baseline []coverage.Block) CoverageReport {
+	var report CoverageReport
+	baseHit := make(map[uint64]bool)
+	for _, block := range baseline {
+		baseHit[block.Offset] = block.Hits > 0
+	}
+	byFunction := make(map[uint64]*functionCoverage)
+	for _, block := range blocks {
+		address := image.Start + block.Offset
+		key := ^uint64(0)
+		name := "(no symbol)"
+		if function := symbols.FunctionAt(image.Functions, address); function != nil {
+			key, name = function.Address, function.Name
+		}
+		fc := byFunction[key]
+		if fc == nil {
+			fc = &functionCoverage{Name: name, Address: key}
+			byFunction[key] = fc
+		}
+		for _, count := range []*coverageCount{&report.Blocks, &fc.Blocks} {
+			count.add(block.Hits > 0, baseline != nil, baseHit[block.Offset])
+		}
+	}
+	for _, fc := range byFunction {
+		report.Functions = append(report.Functions, *fc)
+		if fc.Blocks.Hit > 0 {
+			report.Reached++
+		}
+	}
+	sort.Slice(report.Functions, func(i, j int) bool {
+		return report.Functions[i].Address < report.Functions[j].Address
+	})
+	lines, err := image.LineHits(blocks)
+	if err != nil {
+		report.NoLines = err.Error()
+		return report
+	}
+	var baseLines map[string]map[int]uint64
+	if baseline != nil {
+		baseLines, _ = image.LineHits(baseline)
+	}
+	for path, numbers := range lines {
+		fc := fileCoverage{Path: path}
+		source := readSourceLines(path)
+		for number, hits := range numbers {
+			row := lineCoverage{Number: number, Hits: hits}
+			if number >= 1 && number <= len(source) {
+				row.Source = source[number-1]
+			}
+			if baseline != nil {
+				baseHits := baseLines[path][number]
+				row.New = hits > 0 && baseHits == 0
+				row.Lost = hits == 0 && baseHits > 0
+			}
+			fc.Lines.add(hits > 0, baseline != nil, baseLines[path][number] > 0)
+			fc.Rows = append(fc.Rows, row)
+		}
+		sort.Slice(fc.Rows, func(i, j int) bool {
+			return fc.Rows[i].Number < fc.Rows[j].Number
+		})
+		report.Files = append(report.Files, fc)
+	}
+	sort.Slice(report.Files, func(i, j int) bool {
+		return report.Files[i].Path < report.Files[j].Path
+	})
+	return report
+}
+
+func (c *coverageCount) add(hit bool, compared bool, baseHit bool) {
+	c.Total++
+	if hit {
+		c.Hit++
+	}
+	if compared && hit && !baseHit {
+		c.New++
+	}
+	if compared && !hit && baseHit {
+		c.Lost++
+	}
+}
+
+// readSourceLines reads a source file named by the line table, nil when it isn't around
+func readSourceLines(path string) []string {
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>matcha coverage {{.Target}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 2px 10px; text-align: left; border-bottom: 1px solid #ddd; }
+td.num { text-align: right; font-family: monospace; }
+tr.unreached { background: #fdd; }
+tr.partial { background: #ffd; }
+tr.full { background: #dfd; }
+.new { color: #070; font-weight: bold; }
+.lost { color: #a00; font-weight: bold; }
+pre { margin: 0; }
+table.source td { border: none; padding: 0 10px; }
+</style>
+</head>
+<body>
+<h1>Coverage of {{.Target}}</h1>
+<p>Blocks hit {{.Blocks.Hit}}/{{.Blocks.Total}} ({{.Blocks.Percent}}), functions reached {{.Reached}}/{{len .Functions}}
+{{- if .Baseline}}, against <code>{{.Baseline}}</code> <span class="new">+{{.Blocks.New}}</span> <span class="lost">-{{.Blocks.Lost}}</span> blocks{{end}}</p>
+<h2>Functions</h2>
+<table>
+<tr><th>address</th><th>function</th><th>blocks</th><th>covered</th>{{if .Baseline}}<th>new</th><th>lost</th>{{end}}</tr>
+{{- range .Functions}}
+<tr class="{{if eq .Blocks.Hit 0}}unreached{{else if lt .Blocks.Hit .Blocks.Total}}partial{{else}}full{{end}}"><td class="num">{{if eq .Name "(no symbol)"}}-{{else}}{{printf "0x%x" .Address}}{{end}}</td><td>{{.Name}}</td><td class="num">{{.Blocks.Hit}}/{{.Blocks.Total}}</td><td class="num">{{.Blocks.Percent}}</td>{{if $.Baseline}}<td class="num new">{{if .Blocks.New}}+{{.Blocks.New}}{{end}}</td><td class="num lost">{{if .Blocks.Lost}}-{{.Blocks.Lost}}{{end}}</td>{{end}}</tr>
+{{- end}}
+</table>
+<h2>Source files</h2>
+{{- if .NoLines}}
+<p>No per file breakdown: {{.NoLines}}</p>
+{{- else}}
+<table>
+<tr><th>file</th><th>lines</th><th>covered</th>{{if .Baseline}}<th>new</th><th>lost</th>{{end}}</tr>
+{{- range $i, $file := .Files}}
+<tr class="{{if eq .Lines.Hit 0}}unreached{{else if lt .Lines.Hit .Lines.Total}}partial{{else}}full{{end}}"><td><a href="#file{{$i}}">{{.Path}}</a></td><td class="num">{{.Lines.Hit}}/{{.Lines.Total}}</td><td class="num">{{.Lines.Percent}}</td>{{if $.Baseline}}<td class="num new">{{if .Lines.New}}+{{.Lines.New}}{{end}}</td><td class="num lost">{{if .Lines.Lost}}-{{.Lines.Lost}}{{end}}</td>{{end}}</tr>
+{{- end}}
+</table>
+{{- range $i, $file := .Files}}
+<h3 id="file{{$i}}">{{.Path}}</h3>
+<table class="source">
+{{- range .Rows}}
+<tr class="{{if .Hits}}full{{else}}unreached{{end}}"><td class="num">{{.Number}}</td><td class="num">{{.Hits}}</td><td>{{if .New}}<span class="new">new</span>{{else if .Lost}}<span class="lost">lost</span>{{end}}</td><td><pre>{{.Source}}</pre></td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
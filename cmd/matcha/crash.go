@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"matcha/fuzzer/executor"
+	"matcha/internal/exploitable"
+	"regexp"
+	"strings"
+)
+
+// CrashBucket groups crashes for the dashboard, by the sanitizer error and the top of its
+// stack when there is a report and by signal and pc otherwise
+func (s *State) CrashBucket(result ExecResult) string {
+	if result.Sanitizer != nil {
+		return result.Sanitizer.Bucket()
+	}
+	return fmt.Sprintf("%v at 0x%x", result.Signal, result.PC)
+}
+
+// ClassifyCrash rates how exploitable a crash looks, along with the return addresses found
+// on its stack
+func (s *State) ClassifyCrash(result ExecResult) (exploitable.Classification, []exploitable.Frame) {
+	crash := exploitable.Crash{Signal: result.Signal, Fault: crashFault(result.Fault), Sanitizer: result.Sanitizer, Stderr: result.Stderr}
+	if crash.Fault != nil {
+		crash.Frames = s.crashSymbolizer().Backtrace(crash.Fault)
+	}
+	return exploitable.Classify(crash), crash.Frames
+}
+
+// crashFault copies what the exploitable heuristics look at out of the fault the tracer read
+func crashFault(fault *executor.Fault) *exploitable.Fault {
+	if fault == nil {
+		return nil
+	}
+	mappings := make([]exploitable.Mapping, len(fault.Mappings))
+	for i, m := range fault.Mappings {
+		mappings[i] = exploitable.Mapping{Start: m.Start, End: m.End, Offset: m.Offset, Executable: m.Executable(), Path: m.Path}
+	}
+	return &exploitable.Fault{Regs: fault.Regs, Address: fault.Address, Code: fault.Code, Instruction: fault.Instruction, Stack: fault.Stack, Mappings: mappings}
+}
+
+// crashSymbolizer is made on the first crash, most campaigns never need one
+func (s *State) crashSymbolizer() *exploitable.Symbolizer {
+	if s.symbolizer == nil {
+		s.symbolizer = exploitable.NewSymbolizer()
+	}
+	return s.symbolizer
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// CrashDirName is the directory of the crashes directory a crash is saved in, its rating
+// and kind then a hash of its bucket, so listing the crashes puts the exploitable ones first
+//
+//	EXPLOITABLE_DestAv_1b2c3d4e
+func CrashDirName(bucket string, classification exploitable.Classification) string {
+	hash := sha1.Sum([]byte(bucket))
+	kind := strings.Trim(unsafeNameChars.ReplaceAllString(classification.Kind, "-"), "-")
+	return fmt.Sprintf("%s_%s_%s", classification.Rating, kind, hex.EncodeToString(hash[:4]))
+}
+
+// CrashReport is the text saved next to a unique crash
+func (s *State) CrashReport(result ExecResult, classification exploitable.Classification, frames []exploitable.Frame) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "target: %s\n", s.Path)
+	fmt.Fprintf(&b, "rating: %s\n", classification)
+	if result.Signal != 0 {
+		fmt.Fprintf(&b, "signal: %s\n", result.Signal)
+		fmt.Fprintf(&b, "pc: 0x%x (offset 0x%x)\n", result.PC, result.PC-s.BaseAddress)
+	}
+	fmt.Fprintf(&b, "bucket: %s\n", s.CrashBucket(result))
+	if fault := result.Fault; fault != nil {
+		if frame, ok := s.crashSymbolizer().Symbolize(crashFault(fault), fault.Regs.Rip); ok {
+			fmt.Fprintf(&b, "location: %s\n", frame)
+		}
+		fmt.Fprintf(&b, "fault address: 0x%x (si_code %d)\n", fault.Address, fault.Code)
+		fmt.Fprintf(&b, "instruction: % x\n", fault.Instruction)
+		r := fault.Regs
+		fmt.Fprintf(&b, "\nregisters:\n")
+		fmt.Fprintf(&b, "  rax 0x%016x  rbx 0x%016x  rcx 0x%016x  rdx 0x%016x\n", r.Rax, r.Rbx, r.Rcx, r.Rdx)
+		fmt.Fprintf(&b, "  rsi 0x%016x  rdi 0x%016x  rbp 0x%016x  rsp 0x%016x\n", r.Rsi, r.Rdi, r.Rbp, r.Rsp)
+		fmt.Fprintf(&b, "  r8  0x%016x  r9  0x%016x  r10 0x%016x  r11 0x%016x\n", r.R8, r.R9, r.R10, r.R11)
+		fmt.Fprintf(&b, "  r12 0x%016x  r13 0x%016x  r14 0x%016x  r15 0x%016x\n", r.R12, r.R13, r.R14, r.R15)
+		fmt.Fprintf(&b, "  rip 0x%016x  eflags 0x%x\n", r.Rip, r.Eflags)
+	}
+	if len(frames) > 0 {
+		fmt.Fprintf(&b, "\nreturn addresses on the stack:\n")
+		for _, frame := range frames {
+			fmt.Fprintf(&b, "  %s\n", frame)
+		}
+	}
+	if result.Sanitizer != nil {
+		fmt.Fprintf(&b, "\n%s", result.Sanitizer)
+	}
+	if len(result.Stderr) > 0 {
+		fmt.Fprintf(&b, "\nstderr:\n%s", result.Stderr)
+	}
+	return b.String()
+}
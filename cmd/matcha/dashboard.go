@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// how often the dashboard is redrawn, independent of the stats interval
+const dashboardRefresh = time.Second
+
+// exec speed samples kept for the sparkline, one per redraw
+const sparklineSamples = 60
+
+// crash buckets listed, the most frequent first
+const dashboardBuckets = 5
+
+var sparkRunes = []rune("▁▂▃▄▅▆▇█")
+
+// dashboardActive is set while the alternate screen is shown so a forced exit can put the
+// terminal back
+var dashboardActive atomic.Bool
+
+// Dashboard is the full screen status view drawn on a terminal in place of the stats lines
+type Dashboard struct {
+	out       *os.File
+	started   bool
+	lastDraw  time.Time
+	lastExecs uint64
+	speeds    []float64
+}
+
+// WorkerStats is the row of one fuzzing worker on the dashboard
+type WorkerStats struct {
+	Pid         int
+	Execs       uint64
+	ExecsPerSec float64
+	CaseSize    int
+	Stage       string
+}
+
+// NewDashboard returns a dashboard drawing on out, nil when out is not a terminal so the
+// caller falls back to stats lines
+func NewDashboard(out *os.File) *Dashboard {
+	if _, _, ok := terminalSize(out); !ok {
+		return nil
+	}
+	return &Dashboard{out: out}
+}
+
+// terminalSize asks the terminal behind f for its size, ok is false when f is no terminal
+func terminalSize(f *os.File) (int, int, bool) {
+	var ws struct {
+		Row, Col, X, Y uint16
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 {
+		return 0, 0, false
+	}
+	return int(ws.Col), int(ws.Row), true
+}
+
+// DrawPeriodically redraws the dashboard once dashboardRefresh has passed since the last draw
+func (d *Dashboard) DrawPeriodically(s *State) {
+	if time.Since(d.lastDraw) < dashboardRefresh {
+		return
+	}
+	d.Draw(s)
+}
+
+// Draw switches to the alternate screen on the first call and redraws the whole view
+func (d *Dashboard) Draw(s *State) {
+	now := time.Now()
+	if !d.lastDraw.IsZero() {
+		if since := now.Sub(d.lastDraw).Seconds(); since > 0 {
+			d.speeds = append(d.speeds, float64(s.FuzzCases-d.lastExecs)/since)
+		}
+		if len(d.speeds) > sparklineSamples {
+			d.speeds = d.speeds[len(d.speeds)-sparklineSamples:]
+		}
+	}
+	d.lastDraw = now
+	d.lastExecs = s.FuzzCases
+	if !d.started {
+		// alternate screen and hidden cursor
+		d.out.WriteString("\x1b[?1049h\x1b[?25l")
+		d.started = true
+		dashboardActive.Store(true)
+	}
+	width, height, ok := terminalSize(d.out)
+	if !ok || width == 0 || height == 0 {
+		width, height = 80, 24
+	}
+	lines := d.render(s, now)
+	var screen bytes.Buffer
+	screen.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i >= height {
+			break
+		}
+		if len([]rune(line)) > width {
+			line = string([]rune(line)[:width])
+		}
+		screen.WriteString(line)
+		screen.WriteString("\x1b[K\r\n")
+	}
+	screen.WriteString("\x1b[J")
+	d.out.Write(screen.Bytes())
+}
+
+func (d *Dashboard) render(s *State, now time.Time) []string {
+	stats := s.Stats()
+	recent := stats.ExecsPerSec
+	if len(d.speeds) > 0 {
+		recent = d.speeds[len(d.speeds)-1]
+	}
+	lines := []string{
+		fmt.Sprintf(" matcha %s (pid %d)", s.Path, os.Getpid()),
+		"",
+		fmt.Sprintf(" run time        %s", formatDuration(now.Sub(START_TIME))),
+		fmt.Sprintf(" last new path   %s", formatSince(now, s.LastNewFind)),
+		fmt.Sprintf(" last uniq crash %s", formatSince(now, s.LastUniqueCrash)),
+		"",
+		fmt.Sprintf(" execs           %d", stats.Execs),
+		fmt.Sprintf(" exec speed      %.1f/sec (avg %.1f/sec)", recent, stats.ExecsPerSec),
+		fmt.Sprintf("                 %s", sparkline(d.speeds)),
+		"",
+		fmt.Sprintf(" coverage        %d/%d blocks (%.2f%%)", stats.BlocksHit, stats.BlocksTotal, stats.Coverage),
+		fmt.Sprintf(" corpus          %d", stats.CorpusSize),
+		fmt.Sprintf(" crashes         %d total, %d unique, %d saved", stats.TotalCrashes, stats.UniqueCrashes, stats.SavedCrashes),
+		fmt.Sprintf(" hangs           %d", stats.Hangs),
+		fmt.Sprintf(" stage           %s", s.Stage),
+	}
+	if s.Sequence {
+		lines = append(lines, fmt.Sprintf(" blocks/message  %v", s.MessageCoverage))
+	}
+	lines = append(lines, "", " crash buckets")
+	buckets := s.SortedCrashBuckets()
+	if len(buckets) == 0 {
+		lines = append(lines, "   none yet")
+	}
+	for i, bucket := range buckets {
+		if i == dashboardBuckets {
+			lines = append(lines, fmt.Sprintf("   ... %d more", len(buckets)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("   %-8d %-24s %s", s.CrashBuckets[bucket], s.CrashRatings[bucket], bucket))
+	}
+	lines = append(lines, "", " workers", fmt.Sprintf("   %-4s %-8s %-12s %-12s %-10s %s", "id", "pid", "execs", "execs/sec", "case size", "stage"))
+	for i, worker := range s.WorkerStats(recent) {
+		lines = append(lines, fmt.Sprintf("   %-4d %-8d %-12d %-12.1f %-10d %s", i, worker.Pid, worker.Execs, worker.ExecsPerSec, worker.CaseSize, worker.Stage))
+	}
+	if StopRequested() {
+		lines = append(lines, "", " stopping after the current case")
+	} else if s.Control != nil && s.Control.Paused() {
+		lines = append(lines, "", " paused, resume through the control server")
+	}
+	return lines
+}
+
+// Close leaves the alternate screen so the final stats land on the normal terminal
+func (d *Dashboard) Close() {
+	if !d.started {
+		return
+	}
+	restoreTerminal(d.out)
+	d.started = false
+}
+
+// restoreTerminal leaves the alternate screen and shows the cursor again if the dashboard
+// took over the terminal
+func restoreTerminal(out *os.File) {
+	if dashboardActive.Swap(false) {
+		out.WriteString("\x1b[?25h\x1b[?1049l")
+	}
+}
+
+// WorkerStats are the dashboard rows, one per tracee driven by this process. recent is the
+// exec speed measured since the last redraw
+func (s *State) WorkerStats(recent float64) []WorkerStats {
+	return []WorkerStats{{
+		Pid:         s.Pid,
+		Execs:       s.FuzzCases,
+		ExecsPerSec: recent,
+		CaseSize:    len(s.CurrentFuzzCase),
+		Stage:       s.Stage,
+	}}
+}
+
+// SortedCrashBuckets are the crash buckets, the most frequent first
+func (s *State) SortedCrashBuckets() []string {
+	buckets := make([]string, 0, len(s.CrashBuckets))
+	for bucket := range s.CrashBuckets {
+		buckets = append(buckets, bucket)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if s.CrashBuckets[buckets[i]] != s.CrashBuckets[buckets[j]] {
+			return s.CrashBuckets[buckets[i]] > s.CrashBuckets[buckets[j]]
+		}
+		return buckets[i] < buckets[j]
+	})
+	return buckets
+}
+
+// sparkline scales samples between zero and the biggest one
+func sparkline(samples []float64) string {
+	if len(samples) == 0 {
+		return ""
+	}
+	top := 0.0
+	for _, sample := range samples {
+		top = max(top, sample)
+	}
+	var line strings.Builder
+	for _, sample := range samples {
+		idx := 0
+		if top > 0 {
+			idx = int(sample / top * float64(len(sparkRunes)-1))
+		}
+		line.WriteRune(sparkRunes[idx])
+	}
+	return line.String()
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	return fmt.Sprintf("%d days, %d hrs, %d min, %d sec", days, d/time.Hour, d%time.Hour/time.Minute, d%time.Minute/time.Second)
+}
+
+func formatSince(now time.Time, t time.Time) string {
+	if t.IsZero() {
+		return "none yet"
+	}
+	return formatDuration(now.Sub(t)) + " ago"
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"matcha/fuzzer/executor"
+	"matcha/fuzzer/mutator"
+	"matcha/internal/sanitizer"
+	"syscall"
+	"time"
+)
+
+type CaseOutcome int
+
+const (
+	CaseOK CaseOutcome = iota
+	CaseCrash
+	CaseHang
+)
+
+// ExecResult is how a case ended, Signal, PC and Fault are set for crashes. Sanitizer is the
+// report a sanitizer build wrote before it aborted or exited, Stderr the end of the target's
+// stderr
+type ExecResult struct {
+	Outcome     CaseOutcome
+	Signal      syscall.Signal
+	PC          uint64
+	Fault       *executor.Fault
+	NewCoverage bool
+	Sanitizer   *sanitizer.Report
+	Stderr      []byte
+	// Exited is set when the target exited, every case in spawn mode and the cases that
+	// never reached the restore point in snapshot mode
+	Exited bool
+}
+
+// Backend is a way of running cases against the target, SpawnExecutor or SnapshotExecutor.
+// Fuzz drives any of them, so a backend only has to know how to get a case into the target
+// and back out again
+type Backend interface {
+	// Prepare gets the target ready for the first case and returns the biggest case it
+	// takes and whether cases may change size
+	Prepare() (maxSize int, resize bool, err error)
+	// Run executes CurrentFuzzCase
+	Run() (ExecResult, error)
+	// Reset puts the target back where the next case starts, also after a failed Run
+	Reset() error
+	// Close releases the target, called once whatever happened before
+	Close()
+}
+
+// Fuzz is the fuzz loop shared by every backend: pick and mutate a case, run it, keep
+// crashes and new coverage, until a stop is requested. Failed execs go through ExecFailed
+// and the backend is reset, an error is only returned when the campaign can't go on
+func (s *State) Fuzz(b Backend, custom mutator.Mutator) (int, error) {
+	err := s.fuzz(b, custom)
+	b.Close()
+	return s.Shutdown(), err
+}
+
+func (s *State) fuzz(b Backend, custom mutator.Mutator) error {
+	maxSize, resize, err := b.Prepare()
+	if err != nil {
+		return err
+	}
+	if err := s.SetupMutator(custom, maxSize, resize); err != nil {
+		return err
+	}
+	for !StopRequested() {
+		if s.Control != nil {
+			if err := s.Control.Poll(s); err != nil {
+				return err
+			}
+			if StopRequested() {
+				break
+			}
+		}
+		s.NewCoverageMessage = -1
+		if err := s.NextCase(); err != nil {
+			return err
+		}
+		started := time.Now()
+		result, err := b.Run()
+		if s.Control != nil {
+			s.Control.ObserveExec(time.Since(started))
+		}
+		switch {
+		case err != nil:
+		case result.Outcome == CaseCrash:
+			err = s.RecordCrash(result)
+		case result.Outcome == CaseHang:
+			s.Hangs++
+		}
+		if err == nil {
+			err = b.Reset()
+		}
+		if err != nil {
+			if err := s.ExecFailed(err); err != nil {
+				return err
+			}
+			if err := b.Reset(); err != nil {
+				return fmt.Errorf("reset after a failed exec: %w", err)
+			}
+			continue
+		}
+		s.execErrors = 0
+		s.FuzzCases++
+		if result.NewCoverage {
+			if err := s.Corpus.AddToCorpus(s.NewCoverageCase()); err != nil {
+				return err
+			}
+			s.LastNewFind = time.Now()
+		}
+		s.ReportPeriodically()
+		s.SaveStatePeriodically()
+	}
+	return nil
+}
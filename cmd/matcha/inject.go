@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"matcha/fuzzer/ptrace"
+	"matcha/internal/snapshot"
+	"matcha/internal/symbols"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Injection places the fuzz case in the buffer a register or variable points to at the
+// snapshot point instead of searching memory for an egg, the optional length is updated
+// with the size of every case so cases can be shorter than the original input. With a
+// scratch buffer the case is written to memory mmapped in the tracee and the pointer is
+// redirected to it, so cases can also grow past the original buffer
+type Injection struct {
+	Pointer        InjectTarget
+	Length         *InjectTarget
+	MaxSize        int
+	Scratch        bool
+	ScratchAddress uint64
+}
+
+// InjectTarget is either a register or a variable in memory holding Width bytes
+type InjectTarget struct {
+	Register string
+	Address  uint64
+	Width    int
+}
+
+const DefaultScratchSize = 1024 * 1024
+
+// ParseInjection parses a "pointer[:length]" spec like rdi:rsi, each side is a register
+// or an address spec of a variable (see symbols.Resolve) with an optional /4 width
+func ParseInjection(spec string, maxSize int, scratch bool, target string, baseAddress uint64) (*Injection, error) {
+	if spec == "" {
+		if scratch {
+			return nil, fmt.Errorf("a scratch buffer needs -inject to know where the input goes")
+		}
+		return nil, nil
+	}
+	pointerSpec, lengthSpec, _ := strings.Cut(spec, ":")
+	pointer, err := parseInjectTarget(pointerSpec, target, baseAddress)
+	if err != nil {
+		return nil, err
+	}
+	injection := &Injection{Pointer: pointer, MaxSize: maxSize, Scratch: scratch}
+	if lengthSpec != "" {
+		length, err := parseInjectTarget(lengthSpec, target, baseAddress)
+		if err != nil {
+			return nil, err
+		}
+		injection.Length = &length
+	}
+	if scratch && injection.MaxSize == 0 {
+		injection.MaxSize = DefaultScratchSize
+	}
+	return injection, nil
+}
+
+func parseInjectTarget(spec string, target string, baseAddress uint64) (InjectTarget, error) {
+	var regs syscall.PtraceRegs
+	if _, err := snapshot.RegisterByName(&regs, spec); err == nil {
+		return InjectTarget{Register: spec}, nil
+	}
+	t := InjectTarget{Width: 8}
+	spec, width, found := strings.Cut(spec, "/")
+	if found {
+		w, err := strconv.Atoi(width)
+		if err != nil || (w != 1 && w != 2 && w != 4 && w != 8) {
+			return t, fmt.Errorf("bad width in %s", spec)
+		}
+		t.Width = w
+	}
+	location, err := symbols.Resolve(target, baseAddress, spec)
+	if err != nil {
+		return t, err
+	}
+	t.Address = location.Address
+	return t, nil
+}
+
+func (t InjectTarget) String() string {
+	if t.Register != "" {
+		return t.Register
+	}
+	return fmt.Sprintf("[0x%x]", t.Address)
+}
+
+func (t InjectTarget) Get(s *State, regs *syscall.PtraceRegs) (uint64, error) {
+	if t.Register != "" {
+		value, _ := snapshot.RegisterByName(regs, t.Register)
+		return *value, nil
+	}
+	buffer := make([]byte, 8)
+	if err := s.ReadBufferFromProcess(t.Address, buffer[:t.Width]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buffer), nil
+}
+
+func (t InjectTarget) Set(s *State, regs *syscall.PtraceRegs, value uint64) error {
+	if t.Register != "" {
+		reg, _ := snapshot.RegisterByName(regs, t.Register)
+		*reg = value
+		return nil
+	}
+	buffer := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buffer, value)
+	return s.WriteBufferToProcess(t.Address, buffer[:t.Width])
+}
+
+// SetupInjection works out how big a case can be, without an explicit max the length at the
+// snapshot point is used, or the size of the input used to reach it. Must be called while
+// the tracee sits at the snapshot point
+func (s *State) SetupInjection(payloadSize int) error {
+	regs, err := ptrace.GetRegs(s.Pid)
+	if err != nil {
+		return err
+	}
+	if s.Injection.MaxSize == 0 {
+		s.Injection.MaxSize = payloadSize
+		if s.Injection.Length != nil {
+			length, err := s.Injection.Length.Get(s, &regs)
+			if err != nil {
+				return err
+			}
+			s.Injection.MaxSize = int(length)
+		}
+	}
+	if s.Injection.Scratch {
+		if s.Injection.ScratchAddress, err = s.AllocateScratch(s.Injection.MaxSize); err != nil {
+			return err
+		}
+		fmt.Printf("Injecting Cases At Scratch 0x%x Through %s Max Size %d\n", s.Injection.ScratchAddress, s.Injection.Pointer, s.Injection.MaxSize)
+	} else {
+		pointer, err := s.Injection.Pointer.Get(s, &regs)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Injecting Cases At %s=0x%x Max Size %d\n", s.Injection.Pointer, pointer, s.Injection.MaxSize)
+	}
+	s.CurrentFuzzCase = make([]byte, s.Injection.MaxSize)
+	return nil
+}
+
+// AllocateScratch maps a buffer in the tracee, it is created after the snapshot so restores
+// never touch it
+func (s *State) AllocateScratch(size int) (uint64, error) {
+	address, err := snapshot.InjectSyscall(s.Pid, syscall.SYS_MMAP, 0, uint64(size),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS, ^uint64(0), 0)
+	if err != nil {
+		return 0, err
+	}
+	if address < 0 && address > -4096 {
+		return 0, fmt.Errorf("scratch mmap of %d bytes failed: %w", size, syscall.Errno(-address))
+	}
+	return uint64(address), nil
+}
+
+// InjectFuzzCase writes the current case where the pointer points, or to the scratch buffer
+// with the pointer redirected there. Must be called while the tracee sits at the snapshot point
+func (s *State) InjectFuzzCase() error {
+	regs, err := ptrace.GetRegs(s.Pid)
+	if err != nil {
+		return err
+	}
+	var buffer uint64
+	if s.Injection.Scratch {
+		buffer = s.Injection.ScratchAddress
+		err = s.Injection.Pointer.Set(s, &regs, buffer)
+	} else {
+		buffer, err = s.Injection.Pointer.Get(s, &regs)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.WriteBufferToProcess(buffer, s.CurrentFuzzCase); err != nil {
+		return err
+	}
+	if s.Injection.Length != nil {
+		if err := s.Injection.Length.Set(s, &regs, uint64(len(s.CurrentFuzzCase))); err != nil {
+			return err
+		}
+	}
+	return ptrace.SetRegs(s.Pid, regs)
+}
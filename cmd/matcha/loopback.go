@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// LoopbackClient delivers cases to a network target as a real client on 127.0.0.1. It
+// waits for the tracee to listen, found through the socket inodes in /proc/<pid>/fd and
+// /proc/<pid>/net, connects and sends the case, split into framed messages with Delay
+// between them when Framed is set. In spawn mode the exec ends when the target closes the
+// connection or Timeout passes, the tracee is then stopped with SIGSTOP and killed by the
+// tracer thread. In snapshot mode the connection carries the input used to reach the
+// snapshot and stays open, the restore breakpoint ends every exec
+type LoopbackClient struct {
+	Network  string
+	Port     int
+	Framed   bool
+	Delay    time.Duration
+	Timeout  time.Duration
+	conn     net.Conn
+	finished atomic.Bool
+	message  atomic.Int64
+}
+
+const listenPollInterval = time.Millisecond
+
+// pidfd_open and pidfd_send_signal, a pidfd refers to the process it was opened for so a
+// signal sent through it after the tracee was reaped fails instead of reaching whatever
+// process got the pid next
+const (
+	sysPidfdSendSignal = 424
+	sysPidfdOpen       = 434
+)
+
+func NewLoopbackClient(network string, port int, framed bool, delay time.Duration, timeout time.Duration) (*LoopbackClient, error) {
+	if network != "tcp" && network != "udp" {
+		return nil, fmt.Errorf("loopback network must be tcp or udp not %s", network)
+	}
+	return &LoopbackClient{Network: network, Port: port, Framed: framed, Delay: delay, Timeout: timeout}, nil
+}
+
+// Start delivers data to the tracee in the background, the returned channel is closed once
+// the client is done. With waitClose the client waits for the end of the exec and then asks
+// the tracer to end it, otherwise the connection is left open
+func (c *LoopbackClient) Start(pid int, data []byte, waitClose bool) chan struct{} {
+	done := make(chan struct{})
+	c.finished.Store(false)
+	c.message.Store(-1)
+	data = append([]byte(nil), data...)
+	pidfd := -1
+	if waitClose {
+		// opened now while the tracee is known to be alive
+		fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+		if errno != 0 {
+			fmt.Fprintf(os.Stderr, "WARNING: pidfd_open failed %v, the exec ends at the timeout\n", errno)
+		} else {
+			pidfd = int(fd)
+		}
+	}
+	go func() {
+		defer close(done)
+		err := c.deliver(pid, data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: loopback delivery failed %v\n", err)
+		}
+		if !waitClose {
+			return
+		}
+		if err == nil {
+			c.waitClose()
+		}
+		c.Close()
+		c.finished.Store(true)
+		// interrupt the tracer, it sees the stop and kills the tracee. The tracee may have
+		// exited and been reaped already, the pidfd makes the stop fail then
+		if pidfd >= 0 {
+			syscall.Syscall6(sysPidfdSendSignal, uintptr(pidfd), uintptr(syscall.SIGSTOP), 0, 0, 0, 0)
+			syscall.Close(pidfd)
+		}
+	}()
+	return done
+}
+
+// Message is the index of the last message sent in the current exec, -1 before the first.
+// Messages sent without a delay reach the target together, only the last is credited
+func (c *LoopbackClient) Message() int {
+	return int(c.message.Load())
+}
+
+// Finished reports that the client considers the exec over
+func (c *LoopbackClient) Finished() bool {
+	return c.finished.Load()
+}
+
+func (c *LoopbackClient) deliver(pid int, data []byte) error {
+	port, err := c.waitForListener(pid)
+	if err != nil {
+		return err
+	}
+	c.conn, err = net.DialTimeout(c.Network, fmt.Sprintf("127.0.0.1:%d", port), c.Timeout)
+	if err != nil {
+		return err
+	}
+	for i, message := range SplitPackets(data, c.Framed) {
+		if i > 0 && c.Delay > 0 {
+			time.Sleep(c.Delay)
+		}
+		c.message.Store(int64(i))
+		if _, err := c.conn.Write(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitClose reads until the target closes the connection or the timeout passes
+func (c *LoopbackClient) waitClose() {
+	c.conn.SetReadDeadline(time.Now().Add(c.Timeout))
+	buffer := make([]byte, 4096)
+	for {
+		if _, err := c.conn.Read(buffer); err != nil {
+			return
+		}
+	}
+}
+
+// Close resets tcp connections instead of closing them, the target usually closes first
+// and the reset clears its TIME_WAIT so the next spawn can bind the same port again
+func (c *LoopbackClient) Close() {
+	if c.conn != nil {
+		if tcp, ok := c.conn.(*net.TCPConn); ok {
+			tcp.SetLinger(0)
+		}
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *LoopbackClient) waitForListener(pid int) (int, error) {
+	deadline := time.Now().Add(c.Timeout)
+	for time.Now().Before(deadline) {
+		ports, err := ListeningPorts(pid, c.Network)
+		if err != nil {
+			return 0, err
+		}
+		for _, port := range ports {
+			if c.Port == 0 || c.Port == port {
+				return port, nil
+			}
+		}
+		time.Sleep(listenPollInterval)
+	}
+	return 0, errors.New("timed out waiting for the target to listen")
+}
+
+// ListeningPorts returns the ports of listening tcp sockets, or bound udp sockets, owned by pid
+func ListeningPorts(pid int, network string) ([]int, error) {
+	inodes, err := socketInodes(pid)
+	if err != nil {
+		return nil, err
+	}
+	// TCP_LISTEN and TCP_CLOSE, an unconnected udp socket
+	state := "0A"
+	if network == "udp" {
+		state = "07"
+	}
+	ports := make([]int, 0)
+	for _, table := range []string{network, network + "6"} {
+		f, err := os.Open(fmt.Sprintf("/proc/%d/net/%s", pid, table))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 10 || fields[3] != state || !inodes[fields[9]] {
+				continue
+			}
+			_, portHex, _ := strings.Cut(fields[1], ":")
+			port, err := strconv.ParseUint(portHex, 16, 16)
+			if err == nil {
+				ports = append(ports, int(port))
+			}
+		}
+		f.Close()
+	}
+	return ports, nil
+}
+
+func socketInodes(pid int) (map[string]bool, error) {
+	inodes := make(map[string]bool)
+	fdDir := fmt.Sprintf("/proc/%d/fd", pid)
+	entries, err := os.ReadDir(fdDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		link, err := os.Readlink(fmt.Sprintf("%s/%s", fdDir, e.Name()))
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] = true
+	}
+	return inodes, nil
+}
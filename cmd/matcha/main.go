@@ -1,631 +1,404 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"crypto/md5"
-	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"matcha/internal/snapshot"
+	"matcha/fuzzer/corpus"
+	"matcha/fuzzer/coverage"
+	"matcha/fuzzer/executor"
+	"matcha/fuzzer/mutator"
+	"matcha/fuzzer/ptrace"
+	"matcha/internal/exploitable"
+	"matcha/internal/sanitizer"
+	"matcha/internal/symbols"
 	"math/rand"
 	"os"
-	"os/exec"
 	"runtime"
-	"strconv"
 	"strings"
-	"syscall"
 	"time"
 )
 
 var START_TIME time.Time
 
-type State struct {
-	Pid                  int
-	BaseAddress          uint64
-	TotalBreakPoints     uint64
-	BreakPointsHit       uint64
-	PreviousCoverageHit  uint64
-	FuzzCases            uint64
-	Crashes              uint64
-	SnapshotAddress      uint64
-	SnapshotAddressBytes []byte
-	RestoreAddressBytes  []byte
-	RestoreAddress       uint64
-	SnapshotData         snapshot.Snapshot
-	BreakPointAddresses  []uint64
-	Path                 string
-	CurrentFuzzCase      []byte
-	Corpus               Corpus
-	BreakPoints          map[uint64][]byte
-	DevNull              *os.File
-}
-
-func (c *Corpus) InitCorpus(corpusDir string, crashDir string) {
-	var err error
-	c.CorpusDir = corpusDir
-	c.CrashDir = crashDir
-	entry, err := os.ReadDir(corpusDir)
-	if err != nil {
-		log.Fatal(err)
-	}
-	c.CorpusBuffers = make([][]byte, c.CorpusCount)
-	for _, e := range entry {
-		if e.IsDir() {
-			continue
-		}
-		content, err := os.ReadFile(fmt.Sprintf("%s/%s", corpusDir, e.Name()))
-		if err != nil {
-			log.Fatal(err)
-		}
-		c.CorpusBuffers = append(c.CorpusBuffers, content)
-		c.CorpusCount++
-	}
-	fmt.Printf("Loaded %d items into corpus\n", c.CorpusCount)
-}
-func (c *Corpus) GetCaseByIdx(idx int) []byte {
-	return c.CorpusBuffers[idx]
-}
-
-func (c *Corpus) WriteFuzzCaseToDisk(path string, buffer []byte) {
-	err := os.WriteFile(path, buffer, 0644)
-	if err != nil {
-		panic(err)
-	}
-}
-
-func (c *Corpus) AddToCorpus(data []byte) {
-	c.CorpusBuffers = append(c.CorpusBuffers, data)
-	c.CorpusCount++
-	err := os.WriteFile(fmt.Sprintf("%s/%d.bin", c.CorpusDir, c.CorpusCount), data, 0644)
-	if err != nil {
-		panic(err)
-	}
+// consecutive failed execs before a campaign gives up
+const maxExecErrors = 10
 
-}
-func (c *Corpus) WriteCrashToDisk(data []byte) {
-	hash := md5.Sum(data)
-	name := hex.EncodeToString(hash[:])
-	err := os.WriteFile(fmt.Sprintf("./%s/%s.bin", c.CrashDir, name), data, 0644)
-	if err != nil {
-		panic(err)
-	}
-}
-
-type Corpus struct {
-	CorpusBuffers [][]byte
-	CorpusDir     string
-	CrashDir      string
-	CorpusCount   int
-}
+// bytes of a tracee's stderr kept for sanitizer reports and crash reports
+const stderrLimit = 64 * 1024
 
-func NewState(path string, baseAddress uint64, snapshotAddress uint64, restoreAddress uint64) *State {
-	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0755)
-	if err != nil {
-		panic(err)
-	}
-	state := &State{
-		Path:                path,
-		BreakPoints:         make(map[uint64][]byte),
-		BaseAddress:         0x0,
-		PreviousCoverageHit: 0,
-		SnapshotAddress:     snapshotAddress,
-		RestoreAddress:      restoreAddress,
-		DevNull:             devNull,
-	}
-	state.BaseAddress = baseAddress
-	if state.BaseAddress == 0 {
-		log.Fatal("FAILED TO GET BASE ADDRESS")
-	}
-	fmt.Printf("BaseAddress 0x%x \n", state.BaseAddress)
-	return state
-}
-
-func (s *State) Spawn(args []string) int {
-	path := s.Path
-	//devNull, _ := os.OpenFile(os.DevNull, os.O_WRONLY, 0755)
-	cmd := exec.Command(path)
-	cmd.Args = []string{path}
-	cmd.Args = append(cmd.Args, args...)
-	//cmd.Stdout = os.Stdout
-	//cmd.Stderr = os.Stder
-	cmd.Stdout = s.DevNull
-	cmd.Stderr = s.DevNull
-	cmd.SysProcAttr = &syscall.SysProcAttr{Ptrace: true}
-	err := cmd.Start()
-	if err != nil {
-		log.Fatal(err)
-	}
-	err = cmd.Wait()
-	pid := cmd.Process.Pid
-	//log.Printf("Debugging Pid... %s (%d)", path, pid)
-	s.Pid = pid
-	return pid
-}
-
-func (s *State) GetBreakPointAddresses(path string) []uint64 {
-	bpFile, err := os.OpenFile(path, os.O_RDONLY, 0755)
+type State struct {
+	*executor.Executor
+	FuzzCases          uint64
+	Crashes            uint64
+	Hangs              uint64
+	Corpus             *corpus.Corpus
+	Args               []string
+	Injection          *Injection
+	VirtualFile        *VirtualFile
+	VirtualNetwork     *VirtualNetwork
+	Loopback           *LoopbackClient
+	Sequence           bool
+	MessageCoverage    []uint64
+	NewCoverageMessage int
+	StateFile          string
+	LastStateSave      time.Time
+	Mutator            mutator.Mutator
+	MaxCaseSize        int
+	Report             ReportOptions
+	LastReport         time.Time
+	LastNewFind        time.Time
+	LastUniqueCrash    time.Time
+	CrashBuckets       map[string]uint64
+	CrashRatings       map[string]exploitable.Rating
+	Stage              string
+	Dashboard          *Dashboard
+	Control            *ControlServer
+	symbolizer         *exploitable.Symbolizer
+	lastReportExecs    uint64
+	execErrors         int
+}
+
+func NewState(path string, baseAddress uint64, snapshotAddress uint64, restoreAddress uint64) (*State, error) {
+	e, err := executor.New(path, baseAddress, snapshotAddress, restoreAddress)
 	if err != nil {
-		log.Fatal(err)
-	}
-	defer bpFile.Close()
-	bps := make([]uint64, 0)
-	scanner := bufio.NewScanner(bpFile)
-	for scanner.Scan() {
-		text := strings.Replace(strings.TrimSuffix(scanner.Text(), "\n"), "0x", "", -1)
-		offset, err := strconv.ParseUint(text, 16, 64)
+		return nil, err
+	}
+	fmt.Printf("BaseAddress 0x%x \n", baseAddress)
+	e.Env = sanitizer.Env()
+	e.StderrLimit = stderrLimit
+	return &State{Executor: e, NewCoverageMessage: -1, CrashBuckets: make(map[string]uint64), CrashRatings: make(map[string]exploitable.Rating)}, nil
+}
+
+// InputOptions describe how cases reach the target when they are not written to a file
+type InputOptions struct {
+	Args        []string
+	Injection   *Injection
+	VirtualFile string
+	Network     bool
+	Framed      bool
+	Loopback    *LoopbackClient
+	Sequence    bool
+}
+
+func (s *State) SetupInput(input InputOptions) error {
+	s.Args = input.Args
+	s.Injection = input.Injection
+	s.Loopback = input.Loopback
+	s.Sequence = input.Sequence
+	if input.VirtualFile != "" {
+		virtualFile, err := NewVirtualFile(input.VirtualFile)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		//fmt.Fprintf(os.Stderr, "DEBUG: Setting BP 0x%X\n", s.BaseAddress+offset)
-		breakPoint := s.BaseAddress + offset
-		bps = append(bps, breakPoint)
+		s.VirtualFile = virtualFile
+		s.SyscallHandlers = append(s.SyscallHandlers, s.VirtualFile)
 	}
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+	if input.Network {
+		s.VirtualNetwork = NewVirtualNetwork(input.Framed)
+		s.SyscallHandlers = append(s.SyscallHandlers, s.VirtualNetwork)
 	}
-	return bps
-}
-
-func (s *State) InstrumentProcess(firstTime bool) {
-	if firstTime {
-		for _, breakPoint := range s.BreakPointAddresses {
-			originalBytes := SetBP(s.Pid, uintptr(breakPoint))
-			s.BreakPoints[breakPoint] = originalBytes
-			s.TotalBreakPoints++
-		}
-	} else {
-		for key, _ := range s.BreakPoints {
-			SetBP(s.Pid, uintptr(key))
-		}
+	if s.Loopback != nil {
+		s.Interrupted = s.Loopback.Finished
 	}
-}
-func (s *State) CoverageLoop() bool {
-	for {
-		exited, signal := s.ContinueExec()
-		// child exited spawn new
-		if exited {
-			break
-		} else {
-			// handle a segfault by adding to corpus as well as writing the crash to disk
-			if signal == syscall.SIGSEGV {
-				s.Corpus.WriteCrashToDisk(s.CurrentFuzzCase)
-				s.Corpus.AddToCorpus(s.CurrentFuzzCase)
-				s.Crashes++
-				break
-			}
-		}
-		if s.UpdateCoverage() {
-			return true
-		}
+	if s.Sequence {
+		s.OnNewBlock = s.RecordMessageCoverage
 	}
-	return false
+	return nil
 }
 
-func (s *State) ContinueExec() (bool, syscall.Signal) {
-	ContinueExec(s.Pid)
-	var ws syscall.WaitStatus
-	_, err := syscall.Wait4(s.Pid, &ws, syscall.WALL, nil)
+// LoadBlocks reads the blocks file into the coverage map
+func (s *State) LoadBlocks(blocksFile string) error {
+	addresses, err := coverage.LoadBlocks(blocksFile, s.BaseAddress)
 	if err != nil {
-		log.Fatal("ERROR: State:::ContinueExec:::Syscall.Wait4 ", err)
-	}
-	// if process exited handle that
-	if ws.Exited() {
-		return true, -1
-	}
-	// if we got a signal could mean a crash handle that
-	switch ws.StopSignal() {
-	case syscall.SIGSEGV:
-		return false, syscall.SIGSEGV
-	case syscall.SIGBUS:
-		return false, syscall.SIGBUS
-	case syscall.SIGABRT:
-		return false, syscall.SIGABRT
-	case syscall.SIGTRAP:
-		return false, syscall.SIGTRAP
-	default:
-		return false, syscall.Signal(-1)
+		return err
 	}
+	s.Coverage = coverage.NewMap(addresses)
+	return nil
 }
 
-func (s *State) UpdateCoverage() bool {
-	r := GetReg(s.Pid)
-	pc := r.PC() - 1
-	if s.RestoreAddress == pc {
-		return true
-	}
-	if _, ok := s.BreakPoints[pc]; !ok {
-		log.Fatalf("Not a breakpoint 0x%x\n", pc)
-	}
-	s.BreakPointsHit++
-	originalBytes := s.BreakPoints[pc]
-	DelBP(s.Pid, uintptr(pc), originalBytes)
-	SubRip(s.Pid)
-	delete(s.BreakPoints, pc)
-	return false
+func (s *State) LoadCorpus(corpusDir string, crashesDir string) error {
+	var err error
+	if s.Corpus, err = corpus.Load(corpusDir, crashesDir); err != nil {
+		return err
+	}
+	fmt.Printf("Loaded %d items into corpus\n", s.Corpus.CorpusCount)
+	return nil
 }
 
 func (s *State) PrintStats() {
-	percent := (float32(s.BreakPointsHit) / float32(s.TotalBreakPoints)) * 100.0
+	percent := (float32(s.Coverage.Hit) / float32(s.Coverage.Total)) * 100.0
 	now := time.Now()
 	elapsed := now.Sub(START_TIME)
-	fmt.Printf("INFO: Crashes %d Iterations %d Coverage %d/%d %2f Cases Per Second %f Seconds %f Hours %f Corpus %d\n", s.Crashes, s.FuzzCases, s.BreakPointsHit, s.TotalBreakPoints, percent, float64(s.FuzzCases)/elapsed.Seconds(), elapsed.Seconds(), elapsed.Hours(), s.Corpus.CorpusCount)
-}
-
-func (s *State) RestoreSnapshot() {
-	SetReg(s.Pid, s.SnapshotData.Registers)
-	for i := range s.SnapshotData.Memory {
-		snapshot.WriteRegionToProcess(s.Pid, s.SnapshotData.Memory[i])
-	}
-}
-
-func (s *State) TakeSnapshot() {
-	fmt.Println("Taking Child Snapshot")
-	s.SnapshotAddressBytes = SetBP(s.Pid, uintptr(s.SnapshotAddress))
-	s.RestoreAddressBytes = SetBP(s.Pid, uintptr(s.RestoreAddress))
-	// Run Until We Hit Above Snapshot BreakPoint
-	exited, signal := s.ContinueExec()
-	if signal != syscall.SIGTRAP {
-		if exited {
-			panic("Something went wrong process should not have exited yet")
+	fmt.Printf("INFO: Crashes %d Hangs %d Iterations %d Coverage %d/%d %2f Cases Per Second %f Seconds %f Hours %f Corpus %d\n", s.Crashes, s.Hangs, s.FuzzCases, s.Coverage.Hit, s.Coverage.Total, percent, float64(s.FuzzCases)/elapsed.Seconds(), elapsed.Seconds(), elapsed.Hours(), s.Corpus.CorpusCount)
+	if s.Sequence {
+		fmt.Printf("INFO: New Blocks Per Message %v\n", s.MessageCoverage)
+	}
+}
+
+// RecordCrash saves the case the tracee crashed on with a report of the crash and keeps it
+// in the corpus as well. Crashes are bucketed by CrashBucket for the dashboard, unique ones
+// are rated and saved in the directory of their rating and bucket
+func (s *State) RecordCrash(result ExecResult) error {
+	s.Crashes++
+	bucket := s.CrashBucket(result)
+	if s.CrashBuckets[bucket] == 0 {
+		s.LastUniqueCrash = time.Now()
+	}
+	s.CrashBuckets[bucket]++
+	if !s.Corpus.KnownCrash(s.CurrentFuzzCase) {
+		classification, frames := s.ClassifyCrash(result)
+		s.CrashRatings[bucket] = classification.Rating
+		if _, err := s.Corpus.WriteCrashToDisk(s.CurrentFuzzCase, CrashDirName(bucket, classification), s.CrashReport(result, classification, frames)); err != nil {
+			return err
 		}
 	}
-	r := GetReg(s.Pid)
-	pc := r.PC() - 1
-	if pc != s.SnapshotAddress {
-		log.Panicf("ERROR: Wrong breakpoint wanted 0x%x got 0x%x", s.SnapshotAddress, pc)
-	}
-
-	DelBP(s.Pid, uintptr(pc), s.SnapshotAddressBytes)
-	SubRip(s.Pid)
-	s.SnapshotData = snapshot.NewSnapshot(s.Pid)
-	fmt.Println("Snapshot Complete")
-	// Set BreakPoints for the whole process now to get coverage
-	// You Instrument AFTER the snapshot and reinstrument on the restore
-	fmt.Println("Instrumenting Child")
-	s.InstrumentProcess(true)
+	return s.Corpus.AddToCorpus(s.CurrentFuzzCase)
 }
 
-func (s *State) RestoreLoop() bool {
-	for {
-		ContinueExec(s.Pid)
-		var ws syscall.WaitStatus
-		_, err := syscall.Wait4(s.Pid, &ws, syscall.WALL, nil)
-		if err != nil {
-			log.Fatal("ERROR: State:::ContinueExec:::Syscall.Wait4 ", err)
-		}
-		if ws.Signaled() {
-			fmt.Fprintf(os.Stderr, "\nDEBUG: SIGNAL")
-			panic("HANDLE SIGNALS?")
-		}
-		switch ws.StopSignal() {
-		case syscall.SIGSEGV:
-			os.WriteFile("./crashes/crash.bin", s.CurrentFuzzCase, 0644)
-			fmt.Println("WROTE CRASH")
-			panic("SEGFAULT")
-		case syscall.SIGTRAP:
-			return s.UpdateCoverage()
-		case syscall.SIGABRT:
-			panic("SIGRABORT")
-		case syscall.SIGBUS:
-			panic("SIGBUS")
-		default:
-			if ws.Exited() {
-				panic("child exited")
-			}
-			log.Fatalf("UNKNOWN SIGNAL %d", ws.StopSignal())
-		}
+// ExecFailed decides what a failed exec means for the campaign. A tracee that went away or
+// stopped somewhere unexpected only costs the case, the error is returned once too many
+// execs in a row failed. Anything else is returned straight away
+func (s *State) ExecFailed(err error) error {
+	var unknown *coverage.UnknownBreakPointError
+	if !ptrace.IsGone(err) && !errors.As(err, &unknown) {
+		return err
 	}
-}
-
-func MutateCustom(data []byte) {
-	for i := range data {
-		data[i] = 0x42
+	s.execErrors++
+	if s.execErrors >= maxExecErrors {
+		return fmt.Errorf("%d execs failed in a row, last: %w", s.execErrors, err)
 	}
+	fmt.Fprintf(os.Stderr, "WARNING: dropping case after %v\n", err)
+	return nil
 }
 
-func Mutate(data []byte) {
-	counter := 0
-	// Mutate 5% of the bytes
-	// ByteFlip Bit Flip And Random Insert
-	mutationsPerCycle := 5 * len(data) / 100
-	for {
-		randByte := rand.Intn((len(data))-0) + 0
-		randBitFlip := rand.Intn((7+1)-0) + 0
-		randByteFlip := rand.Intn((len(data))-0) + 0
-		randByteInsert := rand.Intn(255-0) + 0
-		randStrat := rand.Intn(5-0) + 0
-		switch randStrat {
-		case 0:
-			data[randByte] ^= (1 << randBitFlip)
-		case 1:
-			data[randByte] ^= byte(randByteFlip)
-		case 2:
-			data[randByte] = byte(randByteInsert)
-		case 3:
-			data[randByte] = 0x0
-		default:
-		}
-		counter++
-		if counter > mutationsPerCycle {
-			break
-		}
+func GenerateEgg(sz int) []byte {
+	egg := make([]byte, sz)
+	for i := range egg {
+		egg[i] = 0x41
 	}
-}
-func findAllOccurrences(data []byte, search []byte, regionOffset uint64) []uint64 {
-	results := make([]uint64, 0)
-	searchData := data
-	term := search
-	for x, d := bytes.Index(searchData, term), 0; x > -1; x, d = bytes.Index(searchData, term), d+x+1 {
-		results = append(results, uint64((x+d))+uint64(regionOffset))
-		searchData = searchData[x+1:]
-	}
-	return results
+	return egg
 }
 
-func (s *State) FindEgg(egg []byte) ([]uint64, error) {
-	locations := make([]uint64, 0)
-	for _, mem := range s.SnapshotData.Memory {
-		locations = append(locations, findAllOccurrences(mem.RawData, egg, mem.Start)...)
+// snapshotAt and restoreAt are address specs resolved by symbols.Resolve, see
+// SnapshotExecutor for how cases reach the target
+func SnapShotFuzzMode(target string, baseAddress uint64, blocksFile string, corpusDir string, crashesDir string, snapshotAt string, restoreAt string, snapshotFile string, input InputOptions, custom mutator.Mutator, timeout time.Duration, report ReportOptions, stateFile string, resume bool) (int, error) {
+	fState, err := NewSnapshotState(target, baseAddress, snapshotAt, restoreAt)
+	if err != nil {
+		return 0, err
 	}
-	if len(locations) == 0 {
-		return nil, errors.New("Failed to find egg")
+	if err := fState.Setup(blocksFile, corpusDir, crashesDir, input, timeout, report, stateFile, resume); err != nil {
+		return 0, err
 	}
-	return locations, nil
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	return fState.Fuzz(&SnapshotExecutor{State: fState, PayloadPath: fState.PayloadPath(corpusDir), SnapshotFile: snapshotFile}, custom)
 }
 
-func (s *State) ReadBufferFromProcess(address uint64, buffer []byte) {
-	_, err := syscall.PtracePeekData(s.Pid, uintptr(address), buffer)
+// NewSnapshotState resolves the snapshot and restore points for a State running the target
+// from a snapshot
+func NewSnapshotState(target string, baseAddress uint64, snapshotAt string, restoreAt string) (*State, error) {
+	snapshotLocation, err := symbols.Resolve(target, baseAddress, snapshotAt)
 	if err != nil {
-		log.Fatal("ERROR: ReadBufferFromProcess:::PracePeekData ", err)
+		return nil, err
 	}
-	//fmt.Println(string(buffer))
-}
-
-func (s *State) WriteBufferToProcess(address uint64, buffer []byte) {
-	path := fmt.Sprintf("/proc/%d/mem", s.Pid)
-	memPtr, err := os.OpenFile(path, os.O_RDWR, 0644)
-	if err != nil {
-		log.Fatal(err)
+	if snapshotLocation.OnReturn {
+		return nil, errors.New("snapshot point can not be the return of a function")
 	}
-	defer memPtr.Close()
-	_, err = memPtr.Seek(int64(address), 0)
+	restoreLocation, err := symbols.Resolve(target, baseAddress, restoreAt)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	_, err = memPtr.Write(buffer)
+	fmt.Printf("Snapshot At 0x%x (%s) Restore At 0x%x (%s)\n", snapshotLocation.Address, snapshotAt, restoreLocation.Address, restoreAt)
+	s, err := NewState(target, baseAddress, snapshotLocation.Address, restoreLocation.Address)
 	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func GenerateEgg(sz int) []byte {
-	egg := make([]byte, sz)
-	for i := range egg {
-		egg[i] = 0x41
+		return nil, err
 	}
-	return egg
+	s.RestoreOnReturn = restoreLocation.OnReturn
+	// addresses have to line up between runs for a saved snapshot to be usable
+	s.NoASLR = true
+	return s, nil
 }
 
-func ReadEggFromDisk(path string) []byte {
-	egg, err := os.ReadFile(path)
+// SpawnFuzzMode runs every case in a new process, see SpawnExecutor
+func SpawnFuzzMode(target string, baseAddress uint64, blocksFile string, corpusDir string, crashesDir string, input InputOptions, custom mutator.Mutator, timeout time.Duration, report ReportOptions, stateFile string, resume bool) (int, error) {
+	fState, err := NewState(target, baseAddress, 0x0, 0x0)
 	if err != nil {
-		panic(err)
+		return 0, err
 	}
-	return egg
+	if err := fState.Setup(blocksFile, corpusDir, crashesDir, input, timeout, report, stateFile, resume); err != nil {
+		return 0, err
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	return fState.Fuzz(&SpawnExecutor{State: fState, PayloadPath: fState.PayloadPath(corpusDir)}, custom)
 }
 
-func SnapShotFuzzMode(target string, baseAddress uint64, blocksFile string, corpusDir string, crashesDir string, snapshotAddress uint64, restoreAddress uint64) {
-	fState := NewState(target, baseAddress, snapshotAddress, restoreAddress)
-	// init corpus
-	fState.Corpus.InitCorpus(corpusDir, crashesDir)
-	// Get Fuzz Case Size
-	fState.CurrentFuzzCase = make([]byte, len(fState.Corpus.CorpusBuffers[0]))
-	START_TIME = time.Now()
-	runtime.LockOSThread()
-	// Generate Egg
-	//GenerateEggPayload()
-	//egg := GenerateEgg(len(fState.Corpus.CorpusBuffers[0]))
-	egg := ReadEggFromDisk("./egg.bin")
-	payloadPath := fmt.Sprintf("%s/tmp.bin", corpusDir)
-	err := os.WriteFile(payloadPath, egg, 0644)
-	if err != nil {
-		panic(err)
+// Setup is what every fuzz mode does before its backend is prepared, load the corpus and
+// blocks and pick up a saved campaign
+func (s *State) Setup(blocksFile string, corpusDir string, crashesDir string, input InputOptions, timeout time.Duration, report ReportOptions, stateFile string, resume bool) error {
+	if err := s.SetupInput(input); err != nil {
+		return err
 	}
-	// Load Breakpoints into list
-	fState.BreakPointAddresses = fState.GetBreakPointAddresses(blocksFile)
-	// spawn using that path with egg payload there
-	fState.Spawn([]string{payloadPath})
-	// Take Snapshot
-	fState.TakeSnapshot()
-	// We should be stopped at the restore address with the memory snapshotted
-	// Find Egg Now So we know where to overwrite it
-	addressesOfEgg, err := fState.FindEgg(egg)
-	if err != nil {
-		panic(err)
-	}
-	for {
-		nextCase := rand.Intn(len(fState.Corpus.CorpusBuffers))
-		copy(fState.CurrentFuzzCase, fState.Corpus.GetCaseByIdx(nextCase))
-		// Mutate Copy
-		//Mutate(fState.CurrentFuzzCase)
-		Mutate(fState.CurrentFuzzCase)
-		// Write To Process Memory
-		for _, address := range addressesOfEgg {
-			fState.WriteBufferToProcess(address, fState.CurrentFuzzCase)
-		}
-		//snapshot.MemoryDump(fState.Pid)
-		hitRestorePoint := fState.CoverageLoop()
-		if hitRestorePoint {
-			//fState.ContinueExec()
-			fState.RestoreSnapshot()
-			// Reinstrument the process with the remaining breakpoints
-			//fState.InstrumentProcess(fState.FuzzCases == 0)
-			fState.FuzzCases++
-		}
-		if fState.BreakPointsHit > fState.PreviousCoverageHit {
-			fState.PreviousCoverageHit = fState.BreakPointsHit
-			fState.Corpus.AddToCorpus(fState.CurrentFuzzCase)
+	s.Timeout = timeout
+	s.Report = report
+	if report.Dashboard {
+		s.Dashboard = NewDashboard(os.Stdout)
+	}
+	if report.ControlAddress != "" {
+		var err error
+		if s.Control, err = StartControlServer(report.ControlAddress); err != nil {
+			return err
 		}
-		fState.PrintStats()
 	}
-
-}
-func GetBiggestCorpusItemSize(corpusDir string) int64 {
-	var err error
-	var biggest int64 = 0
-	entry, err := os.ReadDir(corpusDir)
-	if err != nil {
-		log.Fatal(err)
+	// init corpus
+	if err := s.LoadCorpus(corpusDir, crashesDir); err != nil {
+		return err
 	}
-	for _, e := range entry {
-		if e.IsDir() {
-			continue
-		}
-		info, err := e.Info()
-		if err != nil {
-			panic(err)
-		}
-		sz := info.Size()
-		if sz > biggest {
-			biggest = sz
-		}
+	START_TIME = time.Now()
+	// Load Breakpoints into list
+	if err := s.LoadBlocks(blocksFile); err != nil {
+		return err
+	}
+	s.StateFile = stateFile
+	if resume {
+		return s.ResumeState()
 	}
-	return biggest
+	return nil
 }
 
-func SpawnFuzzMode(target string, baseAddress uint64, blocksFile string, corpusDir string, crashesDir string) {
-	fState := NewState(target, baseAddress, 0x0, 0x0)
-	// init corpus
-	fState.Corpus.InitCorpus(corpusDir, crashesDir)
-	// get biggest size from corpus
-	fState.CurrentFuzzCase = make([]byte, GetBiggestCorpusItemSize(corpusDir))
-	//fState.CurrentFuzzCase = make([]byte, 0)
-	START_TIME = time.Now()
-	runtime.LockOSThread()
-	payloadPath := fmt.Sprintf("%s/tmp.bin", corpusDir)
-	fState.BreakPointAddresses = fState.GetBreakPointAddresses(blocksFile)
-	var nextCase int = 0
-	for {
-		nextCase = rand.Intn(len(fState.Corpus.CorpusBuffers))
-		copy(fState.CurrentFuzzCase, fState.Corpus.GetCaseByIdx(nextCase))
-		// Mutate Copy
-		Mutate(fState.CurrentFuzzCase)
-		// Write To payload tmp path
-		fState.Corpus.WriteFuzzCaseToDisk(payloadPath, fState.CurrentFuzzCase)
-		// spawn using that path
-		fState.Spawn([]string{payloadPath, "--tree"})
-		fState.InstrumentProcess(fState.FuzzCases == 0)
-		fState.CoverageLoop()
-		if fState.BreakPointsHit > fState.PreviousCoverageHit {
-			fState.PreviousCoverageHit = fState.BreakPointsHit
-			fState.Corpus.AddToCorpus(fState.CurrentFuzzCase)
-		}
-		fState.FuzzCases++
-		fState.PrintStats()
+// RunCase spawns the target on the current case and runs it to the end with the remaining
+// coverage breakpoints set
+func (s *State) RunCase(payloadPath string) (executor.Result, error) {
+	if err := s.Spawn(s.TargetArgs(payloadPath)); err != nil {
+		return executor.Result{}, err
+	}
+	if err := s.Instrument(); err != nil {
+		return executor.Result{}, err
+	}
+	var done chan struct{}
+	if s.Loopback != nil {
+		done = s.Loopback.Start(s.Pid, s.CurrentFuzzCase, true)
 	}
-	runtime.UnlockOSThread()
+	result, err := s.CoverageLoop()
+	// a crashed tracee is still stopped, and the client may be waiting on it, killing it
+	// resets the connection so the client always finishes
+	s.KillTracee()
+	if done != nil {
+		<-done
+	}
+	return result, err
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "snapshot":
+			SnapshotCommand(os.Args[2:])
+			return
+		case "cmin":
+			CminCommand(os.Args[2:])
+			return
+		case "tmin":
+			TminCommand(os.Args[2:])
+			return
+		case "cov":
+			CovCommand(os.Args[2:])
+			return
+		case "replay":
+			ReplayCommand(os.Args[2:])
+			return
+		case "trace":
+			TraceCommand(os.Args[2:])
+			return
+		}
+	}
 	seedPtr := flag.Int64("seed", 0, "seed value")
+	modePtr := flag.String("mode", "spawn", "fuzzing mode, spawn or snapshot")
+	targetPtr := flag.String("target", "./jsonlint", "path of the target binary")
+	targetArgsPtr := flag.String("target-args", "@@ --tree", "arguments of the target split on spaces, @@ is replaced by the path of the case")
+	basePtr := flag.Uint64("base", 0x400000, "base address of the target")
+	blocksPtr := flag.String("blocks", "./libjson_blocks.txt", "file of basic block offsets to instrument")
+	corpusPtr := flag.String("corpus", "./corpus", "corpus directory")
+	crashesPtr := flag.String("crashes", "./crashes", "crashes directory, crashes are saved under a directory named after their rating and bucket")
+	snapshotAtPtr := flag.String("snapshot-at", "", "address, symbol, symbol+offset or file.c:line to take the snapshot at (snapshot mode)")
+	restoreAtPtr := flag.String("restore-at", "", "address, symbol, symbol+offset, ret:symbol or file.c:line to restore the snapshot at (snapshot mode)")
+	snapshotFilePtr := flag.String("snapshot-file", "", "save the snapshot to this file or resume from it if it exists (snapshot mode)")
+	virtualFilePtr := flag.String("virtual-file", "", "serve cases from memory when the target opens this path, it is passed as the input argument instead of a temp file")
+	networkPtr := flag.Bool("network", false, "emulate the target's inet sockets and deliver cases as the data of an accepted client")
+	framedPtr := flag.Bool("framed", false, "cases are packets prefixed by a big endian u16 length (network, loopback)")
+	loopbackPtr := flag.String("loopback", "", "connect to the target over tcp or udp on 127.0.0.1 and send cases as a client")
+	loopbackPortPtr := flag.Int("loopback-port", 0, "port to connect to, by default the first port the target listens on (loopback)")
+	loopbackDelayPtr := flag.Duration("loopback-delay", 0, "delay between framed messages (loopback)")
+	loopbackTimeoutPtr := flag.Duration("loopback-timeout", time.Second, "how long to wait for the target to listen or close the connection (loopback)")
+	sequencePtr := flag.Bool("sequence", false, "cases are framed message sequences for stateful servers, mutated message by message (network, loopback in spawn mode where -loopback-delay lets new blocks be credited to the message that found them)")
+	stateFilePtr := flag.String("state-file", "./matcha.state", "coverage, counters and generator state saved every minute, empty to disable")
+	resumePtr := flag.Bool("resume", false, "carry on the campaign saved in the state file, only blocks not hit yet are instrumented")
+	injectPtr := flag.String("inject", "", "pointer[:length] registers or variables holding the input at the snapshot point, e.g. rdi:rsi or buf:buf_len/4 (snapshot mode)")
+	injectMaxPtr := flag.Int("inject-max", 0, "biggest case to inject, defaults to the length at the snapshot point or the initial input size (snapshot mode)")
+	timeoutPtr := flag.Duration("timeout", time.Second, "cases running longer are stopped and counted as hangs, 0 to wait forever")
+	statsDirPtr := flag.String("stats-dir", ".", "directory for the fuzzer_stats and plot_data files, empty to disable")
+	statsIntervalPtr := flag.Duration("stats-interval", 5*time.Second, "how often stats are printed and the stats files updated")
+	controlPtr := flag.String("control", "", "address like 127.0.0.1:8080 to serve /metrics, /status and the pause, resume, stop and seed endpoints on, empty to disable")
+	noUIPtr := flag.Bool("no-ui", false, "print stats lines instead of the full screen dashboard, which is only shown when stdout is a terminal")
+	mutatorPtr := flag.String("mutator", "", "custom mutator, a Go plugin .so exporting NewMutator or a command line speaking the subprocess protocol")
+	injectScratchPtr := flag.Bool("inject-scratch", false, "mmap a scratch buffer in the target for cases and point the injection pointer at it (snapshot mode)")
 	flag.Parse()
 	if *seedPtr == 0 {
 		flag.PrintDefaults()
 		return
 	}
 	rand.Seed(*seedPtr)
-	SpawnFuzzMode("./jsonlint", 0x400000, "./libjson_blocks.txt", "./corpus", "./crashes")
-	//SpawnFuzzMode("./vpxdec", 0x400000, "./libvpx_blocks.txt", "./corpus", "./crashes")
-	//SpawnFuzzMode("./exif", 0x400000, "./exif_blocks.txt", "./corpus", "./crashes")
-	//SnapShotFuzzMode("./exif", 0x400000, "./exif_blocks.txt", "./corpus", "./crashes", 0x40B782, 0x402B0E)
+	// ./matcha -seed 1 -target ./vpxdec -blocks ./libvpx_blocks.txt
+	// ./matcha -seed 1 -target ./exif -blocks ./exif_blocks.txt
+	// ./matcha -seed 1 -mode snapshot -target ./exif -blocks ./exif_blocks.txt -snapshot-at 0x40B782 -restore-at 0x402B0E
 	// Attempting Server Example
-	//SnapShotFuzzMode("./example/common_server_example", 0x400000, "./example/common_server_example_blocks.txt", "./corpus", "./crashes", 0x4013ef, 0x4012a9)
-}
-
-func SetBP(pid int, address uintptr) []byte {
-	original := make([]byte, 1)
-	_, err := syscall.PtracePeekData(pid, address, original)
-	if err != nil {
-		log.Fatal("ERROR: SetBP:::PracePeekData ", err)
-	}
-	_, err = syscall.PtracePokeData(pid, address, []byte{0xCC})
-	if err != nil {
-		log.Fatal("ERROR: SetBP:::PracePokeData ", err)
-	}
-	return original
-}
-
-func DelBP(pid int, address uintptr, originalBytes []byte) {
-	_, err := syscall.PtracePokeData(pid, address, originalBytes)
-	if err != nil {
-		log.Fatal("ERROR: DelBP::PtracePokeData ", err)
-	}
-}
-
-func SingleStep(pid int) {
-	err := syscall.PtraceSingleStep(pid)
+	// ./matcha -seed 1 -mode snapshot -target ./example/common_server_example -blocks ./example/common_server_example_blocks.txt -snapshot-at crash -restore-at ret:crash -inject rdi
+	// ./matcha -seed 1 -target ./example/common_server_example -blocks ./example/common_server_example_blocks.txt -network
+	// ./matcha -seed 1 -mode snapshot -target ./example/common_server_example -blocks ./example/common_server_example_blocks.txt -snapshot-at crash -restore-at ret:crash -loopback tcp
+	// ./matcha -seed 1 -target ./example/common_server_example -blocks ./example/common_server_example_blocks.txt -network -sequence
+	injection, err := ParseInjection(*injectPtr, *injectMaxPtr, *injectScratchPtr, *targetPtr, *basePtr)
 	if err != nil {
 		log.Fatal(err)
 	}
-}
-
-func ContinueExec(pid int) {
-	err := syscall.PtraceCont(pid, 0)
-	if err != nil {
-		log.Fatal("ERROR: ContinueExec::PtraceCont ", err)
-	}
-}
-
-func GetReg(pid int) syscall.PtraceRegs {
-	var reg syscall.PtraceRegs
-	err := syscall.PtraceGetRegs(pid, &reg)
-	if err != nil {
-		log.Fatal("ERROR: GetReg ", err)
-	}
-	return reg
-}
-
-func GetPC(pid int) uintptr {
-	var reg syscall.PtraceRegs
-	err := syscall.PtraceGetRegs(pid, &reg)
-	if err != nil {
-		log.Fatal("ERROR: GetPC ", err)
+	if *sequencePtr {
+		if !*networkPtr && (*loopbackPtr == "" || *modePtr != "spawn") {
+			log.Fatal("-sequence needs -network, or -loopback in spawn mode")
+		}
+		// sequences are stored framed
+		*framedPtr = true
 	}
-	return uintptr(reg.PC())
-}
-
-func SetPC(pid int, pc uint64) {
-	var regs syscall.PtraceRegs
-	err := syscall.PtraceGetRegs(pid, &regs)
-	if err != nil {
-		log.Fatal(err)
+	input := InputOptions{Args: strings.Fields(*targetArgsPtr), Injection: injection, VirtualFile: *virtualFilePtr, Network: *networkPtr, Framed: *framedPtr, Sequence: *sequencePtr}
+	if *loopbackPtr != "" {
+		input.Loopback, err = NewLoopbackClient(*loopbackPtr, *loopbackPortPtr, *framedPtr, *loopbackDelayPtr, *loopbackTimeoutPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-	regs.SetPC(pc)
-	err = syscall.PtraceSetRegs(pid, &regs)
-	if err != nil {
-		log.Fatal(err)
+	if *resumePtr && *stateFilePtr == "" {
+		log.Fatal("-resume needs a -state-file")
 	}
-}
-
-func SetReg(pid int, r syscall.PtraceRegs) {
-	err := syscall.PtraceSetRegs(pid, &r)
-	if err != nil {
-		log.Fatal(err)
+	var custom mutator.Mutator
+	if *mutatorPtr != "" {
+		if custom, err = mutator.Load(*mutatorPtr); err != nil {
+			log.Fatal(err)
+		}
 	}
-}
-
-func SubRip(pid int) {
-	var regs syscall.PtraceRegs
-	err := syscall.PtraceGetRegs(pid, &regs)
-	if err != nil {
-		log.Fatal(err)
+	report := ReportOptions{StatsDir: *statsDirPtr, Interval: *statsIntervalPtr, Dashboard: !*noUIPtr, ControlAddress: *controlPtr}
+	HandleShutdownSignals()
+	var status int
+	switch *modePtr {
+	case "spawn":
+		status, err = SpawnFuzzMode(*targetPtr, *basePtr, *blocksPtr, *corpusPtr, *crashesPtr, input, custom, *timeoutPtr, report, *stateFilePtr, *resumePtr)
+	case "snapshot":
+		status, err = SnapShotFuzzMode(*targetPtr, *basePtr, *blocksPtr, *corpusPtr, *crashesPtr, *snapshotAtPtr, *restoreAtPtr, *snapshotFilePtr, input, custom, *timeoutPtr, report, *stateFilePtr, *resumePtr)
+	default:
+		log.Fatalf("unknown mode %s", *modePtr)
 	}
-	regs.Rip -= 1
-	err = syscall.PtraceSetRegs(pid, &regs)
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(2)
 	}
+	os.Exit(status)
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"matcha/fuzzer/mutator"
+	"math/rand"
+)
+
+// one case in spliceChance is spliced with another corpus entry before it is mutated
+const spliceChance = 8
+
+// SetupMutator installs custom, or the built-in mutator for the input mode when it is nil,
+// and initializes it from the campaign generator so resumed runs hand it the same seed.
+// maxSize is the biggest case the target can be given, resize lets the built-in byte
+// mutator change the size of cases
+func (s *State) SetupMutator(custom mutator.Mutator, maxSize int, resize bool) error {
+	s.Mutator = custom
+	s.MaxCaseSize = maxSize
+	if s.Mutator == nil && s.Sequence {
+		s.Mutator = &mutator.Sequence{Corpus: s.Corpus}
+	} else if s.Mutator == nil {
+		s.Mutator = &mutator.Havoc{Resize: resize}
+	}
+	return s.Mutator.Init(rand.Int63())
+}
+
+// NextCase mutates a copy of a random corpus entry into CurrentFuzzCase, running the
+// mutator's splice and post-process hooks when it has them
+func (s *State) NextCase() error {
+	entry := s.Corpus.GetCaseByIdx(rand.Intn(len(s.Corpus.CorpusBuffers)))
+	data := append(s.CurrentFuzzCase[:0], entry[:min(len(entry), s.MaxCaseSize)]...)
+	var err error
+	s.Stage = mutatorStage(s.Mutator)
+	if splicer, ok := s.Mutator.(mutator.Splicer); ok && s.Corpus.CorpusCount > 1 && rand.Intn(spliceChance) == 0 {
+		s.Stage = "splice+" + s.Stage
+		other := s.Corpus.GetCaseByIdx(rand.Intn(len(s.Corpus.CorpusBuffers)))
+		if data, err = splicer.Splice(data, other, s.MaxCaseSize); err != nil {
+			return err
+		}
+	}
+	if data, err = s.Mutator.Mutate(data, s.MaxCaseSize); err != nil {
+		return err
+	}
+	if postProcessor, ok := s.Mutator.(mutator.PostProcessor); ok {
+		if data, err = postProcessor.PostProcess(data); err != nil {
+			return err
+		}
+	}
+	s.CurrentFuzzCase = data[:min(len(data), s.MaxCaseSize)]
+	return nil
+}
+
+// mutatorStage names the mutation stage shown on the dashboard
+func mutatorStage(m mutator.Mutator) string {
+	switch m.(type) {
+	case *mutator.Havoc:
+		return "havoc"
+	case *mutator.Sequence:
+		return "sequence"
+	default:
+		return "custom"
+	}
+}
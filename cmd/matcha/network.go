@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/binary"
+	"matcha/fuzzer/executor"
+	"matcha/fuzzer/mutator"
+	"matcha/fuzzer/ptrace"
+	"matcha/internal/snapshot"
+	"os"
+	"syscall"
+)
+
+const (
+	socketEmulated = iota
+	socketListening
+	socketClient
+)
+
+// VirtualNetwork emulates the tracee's inet sockets so a server can be fuzzed without any
+// real network. Sockets are swapped for /dev/null fds so the kernel still hands out fd
+// numbers, bind listen and friends succeed without doing anything, accept on a listening
+// socket returns a fake client and reads from the client are answered with the fuzz case.
+// With Framed set the case is a sequence of packets each prefixed by a big endian u16
+// length and every recv returns at most one packet. Once the case is used up recv returns
+// 0 like a closed connection, accepting a second client ends the run with exit_group
+type VirtualNetwork struct {
+	Framed      bool
+	Sockets     map[int]int
+	Port        uint16
+	Accepted    int
+	Packet      int
+	Offset      int
+	saved       VirtualNetworkState
+	inSyscall   bool
+	pendingKind int
+	pendingRet  int64
+	pendingAddr [2]uint64
+}
+
+type VirtualNetworkState struct {
+	Sockets  map[int]int
+	Port     uint16
+	Accepted int
+}
+
+const (
+	netPendingNone = iota
+	netPendingResult
+	netPendingSocket
+	netPendingAccept
+)
+
+func NewVirtualNetwork(framed bool) *VirtualNetwork {
+	return &VirtualNetwork{Framed: framed, Sockets: make(map[int]int)}
+}
+
+func (n *VirtualNetwork) Attach(pid int) error {
+	if err := ptrace.SetOptions(pid, syscall.PTRACE_O_TRACESYSGOOD); err != nil {
+		return err
+	}
+	n.Sockets = make(map[int]int)
+	n.Port = 0
+	n.Accepted = 0
+	n.resetStream()
+	n.inSyscall = false
+	return nil
+}
+
+func (n *VirtualNetwork) Save() {
+	n.saved = VirtualNetworkState{Sockets: make(map[int]int), Port: n.Port, Accepted: n.Accepted}
+	for fd, kind := range n.Sockets {
+		n.saved.Sockets[fd] = kind
+	}
+}
+
+// Restore puts the sockets back as they were at the snapshot, the next case is delivered
+// from its first packet
+func (n *VirtualNetwork) Restore() {
+	n.Sockets = make(map[int]int)
+	for fd, kind := range n.saved.Sockets {
+		n.Sockets[fd] = kind
+	}
+	n.Port = n.saved.Port
+	n.Accepted = n.saved.Accepted
+	n.resetStream()
+	n.inSyscall = false
+}
+
+func (n *VirtualNetwork) resetStream() {
+	n.Packet = 0
+	n.Offset = 0
+}
+
+func (n *VirtualNetwork) HandleSyscall(e *executor.Executor) error {
+	regs, err := ptrace.GetRegs(e.Pid)
+	if err != nil {
+		return err
+	}
+	if !n.inSyscall {
+		n.inSyscall = true
+		n.pendingKind = netPendingNone
+		return n.enter(e, &regs)
+	}
+	n.inSyscall = false
+	return n.exit(e, &regs)
+}
+
+func (n *VirtualNetwork) enter(e *executor.Executor, regs *syscall.PtraceRegs) error {
+	fd := int(int32(regs.Rdi))
+	kind, emulated := n.Sockets[fd]
+	switch regs.Orig_rax {
+	case syscall.SYS_SOCKET:
+		if regs.Rdi != syscall.AF_INET && regs.Rdi != syscall.AF_INET6 {
+			return nil
+		}
+		n.pendingKind = netPendingSocket
+		return n.openDevNull(e, regs, regs.Rsi)
+	case syscall.SYS_BIND:
+		if !emulated {
+			return nil
+		}
+		// sin_port and sin6_port sit at the same offset
+		port := make([]byte, 2)
+		if err := e.ReadBufferFromProcess(regs.Rsi+2, port); err != nil {
+			return err
+		}
+		n.Port = binary.BigEndian.Uint16(port)
+		return n.skip(e, regs, 0)
+	case syscall.SYS_LISTEN:
+		if !emulated {
+			return nil
+		}
+		n.Sockets[fd] = socketListening
+		return n.skip(e, regs, 0)
+	case syscall.SYS_ACCEPT, syscall.SYS_ACCEPT4:
+		if !emulated || kind != socketListening {
+			return nil
+		}
+		if n.Accepted > 0 {
+			// the client already had its case, nothing else to serve
+			return executor.ReplaceSyscall(e.Pid, regs, syscall.SYS_EXIT_GROUP, 0)
+		}
+		n.pendingAddr = [2]uint64{regs.Rsi, regs.Rdx}
+		flags := uint64(0)
+		if regs.Orig_rax == syscall.SYS_ACCEPT4 {
+			flags = regs.R10
+		}
+		n.pendingKind = netPendingAccept
+		return n.openDevNull(e, regs, flags)
+	case syscall.SYS_READ, syscall.SYS_RECVFROM:
+		if !emulated || kind == socketListening {
+			return nil
+		}
+		data := n.nextPacket(e.CurrentFuzzCase, int(regs.Rdx))
+		if err := e.WriteBufferToProcess(regs.Rsi, data); err != nil {
+			return err
+		}
+		if regs.Orig_rax == syscall.SYS_RECVFROM && regs.R8 != 0 {
+			if err := n.writePeer(e, regs.R8, regs.R9); err != nil {
+				return err
+			}
+		}
+		return n.skip(e, regs, int64(len(data)))
+	case syscall.SYS_WRITE, syscall.SYS_SENDTO:
+		if !emulated {
+			return nil
+		}
+		// whatever the server answers goes nowhere
+		return n.skip(e, regs, int64(regs.Rdx))
+	case syscall.SYS_CONNECT, syscall.SYS_SETSOCKOPT, syscall.SYS_SHUTDOWN:
+		if emulated {
+			return n.skip(e, regs, 0)
+		}
+	case syscall.SYS_GETSOCKNAME, syscall.SYS_GETPEERNAME:
+		if emulated {
+			if err := n.writePeer(e, regs.Rsi, regs.Rdx); err != nil {
+				return err
+			}
+			return n.skip(e, regs, 0)
+		}
+	case syscall.SYS_GETSOCKOPT:
+		if emulated {
+			if regs.R10 != 0 {
+				if err := e.WriteBufferToProcess(regs.R10, make([]byte, 4)); err != nil {
+					return err
+				}
+			}
+			return n.skip(e, regs, 0)
+		}
+	case syscall.SYS_CLOSE:
+		delete(n.Sockets, fd)
+	}
+	return nil
+}
+
+func (n *VirtualNetwork) exit(e *executor.Executor, regs *syscall.PtraceRegs) error {
+	ret := int64(regs.Rax)
+	switch n.pendingKind {
+	case netPendingResult:
+		regs.Rax = uint64(n.pendingRet)
+		return ptrace.SetRegs(e.Pid, *regs)
+	case netPendingSocket:
+		if ret >= 0 {
+			n.Sockets[int(ret)] = socketEmulated
+		}
+	case netPendingAccept:
+		if ret >= 0 {
+			n.Sockets[int(ret)] = socketClient
+			n.Accepted++
+			n.resetStream()
+			if n.pendingAddr[0] != 0 {
+				return n.writePeer(e, n.pendingAddr[0], n.pendingAddr[1])
+			}
+		}
+	}
+	return nil
+}
+
+func (n *VirtualNetwork) skip(e *executor.Executor, regs *syscall.PtraceRegs, result int64) error {
+	n.pendingKind = netPendingResult
+	n.pendingRet = result
+	return executor.SkipSyscall(e.Pid, regs)
+}
+
+// openDevNull turns the syscall being entered into an open of /dev/null carrying over the
+// SOCK_NONBLOCK and SOCK_CLOEXEC flags, which share values with their O_ counterparts
+func (n *VirtualNetwork) openDevNull(e *executor.Executor, regs *syscall.PtraceRegs, sockFlags uint64) error {
+	flags := uint64(syscall.O_RDWR) | sockFlags&(syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+	path, err := snapshot.WriteScratchString(e.Pid, os.DevNull)
+	if err != nil {
+		return err
+	}
+	return executor.ReplaceSyscall(e.Pid, regs, syscall.SYS_OPENAT, snapshot.AtFdCwd, path, flags, 0)
+}
+
+// writePeer fills a sockaddr_in for 127.0.0.1 on the bound port
+func (n *VirtualNetwork) writePeer(e *executor.Executor, addr uint64, addrLen uint64) error {
+	sockaddr := make([]byte, 16)
+	binary.LittleEndian.PutUint16(sockaddr[0:], syscall.AF_INET)
+	binary.BigEndian.PutUint16(sockaddr[2:], n.Port)
+	copy(sockaddr[4:], []byte{127, 0, 0, 1})
+	if addrLen != 0 {
+		length := make([]byte, 4)
+		if err := e.ReadBufferFromProcess(addrLen, length); err != nil {
+			return err
+		}
+		if max := binary.LittleEndian.Uint32(length); max < uint32(len(sockaddr)) {
+			sockaddr = sockaddr[:max]
+		}
+		binary.LittleEndian.PutUint32(length, 16)
+		if err := e.WriteBufferToProcess(addrLen, length); err != nil {
+			return err
+		}
+	}
+	return e.WriteBufferToProcess(addr, sockaddr)
+}
+
+// nextPacket returns up to size bytes of the current packet and advances the stream
+func (n *VirtualNetwork) nextPacket(data []byte, size int) []byte {
+	packets := SplitPackets(data, n.Framed)
+	for n.Packet < len(packets) && n.Offset >= len(packets[n.Packet]) {
+		n.Packet++
+		n.Offset = 0
+	}
+	if n.Packet >= len(packets) {
+		return nil
+	}
+	packet := packets[n.Packet][n.Offset:]
+	if len(packet) > size {
+		packet = packet[:size]
+	}
+	n.Offset += len(packet)
+	return packet
+}
+
+// SplitPackets splits a case into packets, unframed cases are a single packet. A frame
+// whose length runs past the end of the case is cut short
+func SplitPackets(data []byte, framed bool) [][]byte {
+	if !framed {
+		return [][]byte{data}
+	}
+	return mutator.DecodeSequence(data)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitPackets(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		framed bool
+		want   [][]byte
+	}{
+		{"unframed is one packet", []byte{0, 1, 'a'}, false, [][]byte{{0, 1, 'a'}}},
+		{"unframed empty", nil, false, [][]byte{nil}},
+		{"framed", []byte{0, 1, 'a', 0, 2, 'b', 'c'}, true, [][]byte{[]byte("a"), []byte("bc")}},
+		{"framed empty", nil, true, [][]byte{}},
+		{"framed truncated length prefix", []byte{0, 1, 'a', 0}, true, [][]byte{[]byte("a")}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := SplitPackets(test.data, test.framed)
+			if len(got) != len(test.want) {
+				t.Fatalf("SplitPackets = %q, want %q", got, test.want)
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], test.want[i]) {
+					t.Errorf("packet %d = %q, want %q", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
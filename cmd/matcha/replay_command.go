@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"matcha/fuzzer/corpus"
+	"matcha/internal/blocktrace"
+	"os"
+	"runtime"
+	"time"
+)
+
+// ReplayCommand runs one input against the target the way a campaign runs its cases, with
+// the target's stdout and stderr shown, and reports how it ended and the blocks it hit. The
+// exit status is 1 when the input crashes the target. With -state the flags of the campaign
+// are used, so a crash is replayed the way it was found
+//
+//	matcha replay -state ./matcha.state crash.bin
+//	matcha replay -target ./exif -blocks ./exif_blocks.txt crashes/<md5>.bin
+//	matcha replay -mode snapshot -snapshot-file exif.snap -snapshot-at 0x40B782 -restore-at 0x402B0E -target ./exif -blocks ./exif_blocks.txt -trace trace.txt crash.min
+func ReplayCommand(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	modePtr := fs.String("mode", "spawn", "run the input in a spawned target or from a snapshot")
+	snapshotAtPtr := fs.String("snapshot-at", "", "snapshot point of the campaign (snapshot mode)")
+	restoreAtPtr := fs.String("restore-at", "", "restore point of the campaign (snapshot mode)")
+	snapshotFilePtr := fs.String("snapshot-file", "", "saved snapshot to run the input from, taken anew when empty (snapshot mode)")
+	injectPtr := fs.String("inject", "", "pointer[:length] the campaign injected cases through (snapshot mode)")
+	injectMaxPtr := fs.Int("inject-max", 0, "biggest case to inject (snapshot mode)")
+	injectScratchPtr := fs.Bool("inject-scratch", false, "inject through a scratch buffer (snapshot mode)")
+	timeoutPtr := fs.Duration("timeout", time.Second, "the input is stopped and reported as a hang after this long, 0 to wait forever")
+	tracePtr := fs.String("trace", "", "write every block executed, in order, to this trace file, see matcha trace")
+	traceTextPtr := fs.Bool("trace-text", false, "write the trace as text instead of binary")
+	options := addTargetFlags(fs)
+	if err := options.Parse(fs, args); err != nil {
+		log.Fatal(err)
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: matcha replay [flags] <input>")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	image, err := LoadCoverageImage(*options.target)
+	if err != nil {
+		log.Fatal(err)
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var s *State
+	input := InputOptions{}
+	switch *modePtr {
+	case "spawn":
+		s, err = NewState(*options.target, *options.base, 0x0, 0x0)
+	case "snapshot":
+		if input.Injection, err = ParseInjection(*injectPtr, *injectMaxPtr, *injectScratchPtr, *options.target, *options.base); err == nil {
+			s, err = NewSnapshotState(*options.target, *options.base, *snapshotAtPtr, *restoreAtPtr)
+		}
+	default:
+		err = fmt.Errorf("unknown mode %s", *modePtr)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := options.Setup(s, input); err != nil {
+		log.Fatal(err)
+	}
+	// the input stands in for the corpus, snapshot mode reaches the snapshot with it
+	s.Corpus = &corpus.Corpus{CorpusBuffers: [][]byte{data}, CorpusCount: 1}
+	// stderr stays captured so a sanitizer report is parsed like in a campaign
+	s.Stdout = os.Stdout
+	s.Timeout = *timeoutPtr
+	var trace blocktrace.Recorder
+	if *tracePtr != "" {
+		s.OnBlock = func(address uint64) {
+			trace.Record(address - s.BaseAddress)
+		}
+	}
+	var backend Backend
+	if *modePtr == "snapshot" {
+		backend = &SnapshotExecutor{State: s, PayloadPath: s.PayloadPath(os.TempDir()), SnapshotFile: *snapshotFilePtr}
+	} else {
+		backend = &SpawnExecutor{State: s, PayloadPath: s.PayloadPath(os.TempDir())}
+	}
+	result, err := s.Replay(backend, data)
+	backend.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stderr.Write(result.Stderr)
+	switch {
+	case result.Sanitizer != nil:
+		fmt.Printf("INFO: Crashed With %s %s\n", result.Sanitizer.Tool, result.Sanitizer.Type)
+	case result.Outcome == CaseCrash:
+		fmt.Printf("INFO: Crashed With %s At 0x%x %s\n", result.Signal, result.PC, image.Describe(result.PC-s.BaseAddress))
+	case result.Outcome == CaseHang:
+		fmt.Printf("INFO: Hung Past The %s Timeout\n", s.Timeout)
+	case !result.Exited:
+		fmt.Println("INFO: Reached The Restore Point")
+	case s.ExitStatus.Signaled():
+		fmt.Printf("INFO: Killed By %s\n", s.ExitStatus.Signal())
+	default:
+		fmt.Printf("INFO: Exited With Status %d\n", s.ExitStatus.ExitStatus())
+	}
+	if result.Outcome == CaseCrash {
+		classification, _ := s.ClassifyCrash(result)
+		fmt.Printf("INFO: Rated %s\n", classification)
+	}
+	hit := s.Coverage.HitBlocks()
+	fmt.Printf("INFO: Covered %d/%d Blocks\n", len(hit), len(s.Coverage.Addresses))
+	for _, address := range hit {
+		fmt.Printf("  0x%x %s\n", address-s.BaseAddress, image.Describe(address-s.BaseAddress))
+	}
+	if *tracePtr != "" {
+		if err := blocktrace.Save(*tracePtr, trace.Entries, *traceTextPtr); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("INFO: Wrote %d Executed Blocks To %s\n", len(trace.Entries), *tracePtr)
+	}
+	if result.Outcome == CaseCrash {
+		os.Exit(1)
+	}
+}
+
+// Replay prepares the backend with data standing in for the corpus and runs data once
+func (s *State) Replay(b Backend, data []byte) (ExecResult, error) {
+	maxSize, _, err := b.Prepare()
+	if err != nil {
+		return ExecResult{}, err
+	}
+	if len(data) > maxSize {
+		fmt.Fprintf(os.Stderr, "WARNING: input cut to the %d bytes the target takes\n", maxSize)
+	}
+	s.CurrentFuzzCase = append(s.CurrentFuzzCase[:0], data[:min(len(data), maxSize)]...)
+	return b.Run()
+}
@@ -0,0 +1,42 @@
+package main
+
+import "matcha/fuzzer/mutator"
+
+// CurrentMessage is the index of the message the target is handling, the last one the
+// network emulation or the loopback client delivered. -1 when it is not known
+func (s *State) CurrentMessage() int {
+	switch {
+	case s.VirtualNetwork != nil:
+		return s.VirtualNetwork.Packet
+	case s.Loopback != nil:
+		return s.Loopback.Message()
+	}
+	return -1
+}
+
+// RecordMessageCoverage credits a new block to the message being handled when it was hit
+func (s *State) RecordMessageCoverage(address uint64) {
+	message := s.CurrentMessage()
+	if message < 0 {
+		return
+	}
+	for len(s.MessageCoverage) <= message {
+		s.MessageCoverage = append(s.MessageCoverage, 0)
+	}
+	s.MessageCoverage[message]++
+	s.NewCoverageMessage = max(s.NewCoverageMessage, message)
+}
+
+// NewCoverageCase is the part of the current case worth keeping after it found new
+// coverage. For sequences that is the prefix up to the last message that hit a new block,
+// the messages after it did not add anything
+func (s *State) NewCoverageCase() []byte {
+	if !s.Sequence || s.NewCoverageMessage < 0 {
+		return s.CurrentFuzzCase
+	}
+	messages := mutator.DecodeSequence(s.CurrentFuzzCase)
+	if s.NewCoverageMessage+1 < len(messages) {
+		messages = messages[:s.NewCoverageMessage+1]
+	}
+	return mutator.EncodeSequence(messages)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+var stopRequested atomic.Bool
+
+// HandleShutdownSignals makes SIGINT and SIGTERM ask the fuzz loop to stop after the current
+// case instead of killing matcha with its tracee still stopped. A second signal exits at once
+func HandleShutdownSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		fmt.Fprintf(os.Stderr, "INFO: Got %s Stopping After The Current Case\n", sig)
+		stopRequested.Store(true)
+		<-signals
+		restoreTerminal(os.Stdout)
+		os.Exit(130)
+	}()
+}
+
+func StopRequested() bool {
+	return stopRequested.Load()
+}
+
+// Shutdown stops a mutator process and the control server, leaves the dashboard, saves the
+// campaign state and reports the final stats, the backend has been closed already. The exit
+// status is 1 when crashes were found so CI jobs fail
+func (s *State) Shutdown() int {
+	if closer, ok := s.Mutator.(io.Closer); ok {
+		closer.Close()
+	}
+	if s.Control != nil {
+		s.Control.Close()
+	}
+	if s.Dashboard != nil {
+		s.Dashboard.Close()
+		s.Dashboard = nil
+	}
+	if err := s.SaveState(); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: saving state: %v\n", err)
+	}
+	fmt.Println("Final Stats")
+	s.ReportStats()
+	if s.Crashes > 0 {
+		return 1
+	}
+	return 0
+}
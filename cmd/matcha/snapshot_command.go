@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"matcha/internal/snapshot"
+	"os"
+)
+
+func snapshotUsage() {
+	fmt.Fprintln(os.Stderr, "usage: matcha snapshot inspect <file>")
+	fmt.Fprintln(os.Stderr, "       matcha snapshot diff <file> <file>")
+	os.Exit(2)
+}
+
+func SnapshotCommand(args []string) {
+	if len(args) < 2 {
+		snapshotUsage()
+	}
+	switch args[0] {
+	case "inspect":
+		snap, err := snapshot.Load(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		InspectSnapshot(snap)
+	case "diff":
+		if len(args) < 3 {
+			snapshotUsage()
+		}
+		a, err := snapshot.Load(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		b, err := snapshot.Load(args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		diffs := snapshot.Diff(a, b)
+		for _, d := range diffs {
+			fmt.Println(d)
+		}
+		if len(diffs) > 0 {
+			os.Exit(1)
+		}
+	default:
+		snapshotUsage()
+	}
+}
+
+func InspectSnapshot(snap snapshot.Snapshot) {
+	fmt.Printf("Pid %d\n", snap.Pid)
+	fmt.Println("Registers")
+	for _, r := range snapshot.Registers(snap.Registers) {
+		fmt.Printf("  %-10s 0x%016x\n", r.Name, r.Value)
+	}
+	fmt.Printf("Regions %d (%d bytes)\n", len(snap.Memory), snap.Size())
+	for _, region := range snap.Memory {
+		fmt.Printf("  0x%012x-0x%012x %10d %s\n", region.Start, region.End, region.End-region.Start, region.Name)
+	}
+	fmt.Printf("Files %d\n", len(snap.Files))
+	for _, f := range snap.Files {
+		fmt.Printf("  %3d %-40s offset %d flags 0%o\n", f.Fd, f.Path, f.Offset, f.Flags)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"matcha/fuzzer/corpus"
+	"matcha/fuzzer/mutator"
+	"matcha/internal/snapshot"
+	"os"
+)
+
+// SnapshotExecutor runs every case from a snapshot taken once the target reached the
+// snapshot address, the restore address ends a case and the snapshot is put back. Cases are
+// written over the egg unless an injection names the registers holding the input buffer,
+// or served from memory when the target reads its input file or sockets. If SnapshotFile is
+// set the snapshot is saved there, or resumed from when it already exists. A case that makes
+// the target exit ends like any other, the next one starts in a new tracee
+type SnapshotExecutor struct {
+	*State
+	PayloadPath    string
+	SnapshotFile   string
+	egg            []byte
+	addressesOfEgg []uint64
+	// closed once the loopback client sent the egg, it owns the connection until then
+	delivered chan struct{}
+}
+
+func (e *SnapshotExecutor) Prepare() (int, bool, error) {
+	// Generate Egg
+	//GenerateEggPayload()
+	//egg := GenerateEgg(len(e.Corpus.CorpusBuffers[0]))
+	var egg []byte
+	var err error
+	if e.Injection != nil || len(e.SyscallHandlers) > 0 {
+		// No egg to search for, reach the snapshot with the biggest corpus entry so the
+		// target's buffer is as big as possible
+		egg = e.Corpus.GetCaseByIdx(e.Corpus.BiggestCaseIdx())
+	} else if egg, err = os.ReadFile("./egg.bin"); err != nil {
+		return 0, false, err
+	}
+	e.egg = egg
+	if len(e.SyscallHandlers) > 0 {
+		e.CurrentFuzzCase = append(e.CurrentFuzzCase[:0], egg...)
+	} else if err := corpus.WriteFuzzCaseToDisk(e.PayloadPath, egg); err != nil {
+		return 0, false, err
+	}
+	// spawn using that path with egg payload there
+	if err := e.Spawn(e.TargetArgs(e.PayloadPath)); err != nil {
+		return 0, false, err
+	}
+	if _, err := os.Stat(e.SnapshotFile); e.SnapshotFile != "" && err == nil {
+		snap, err := snapshot.Load(e.SnapshotFile)
+		if err != nil {
+			return 0, false, err
+		}
+		fmt.Println("Resuming Child From Snapshot")
+		if err := e.ResumeSnapshot(snap); err != nil {
+			return 0, false, err
+		}
+		fmt.Printf("Snapshot Restored %d regions %d fds\n", len(snap.Memory), len(snap.Files))
+	} else {
+		if err := e.takeSnapshot(); err != nil {
+			return 0, false, err
+		}
+		if e.SnapshotFile != "" {
+			if err := e.SnapshotData.Save(e.SnapshotFile); err != nil {
+				return 0, false, err
+			}
+			fmt.Printf("Saved Snapshot To %s\n", e.SnapshotFile)
+		}
+	}
+	// We should be stopped at the restore address with the memory snapshotted
+	// Find Egg Now So we know where to overwrite it
+	switch {
+	case e.Sequence:
+		return mutator.MaxSequenceSize, true, nil
+	case e.Injection != nil:
+		err := e.SetupInjection(len(egg))
+		return e.Injection.MaxSize, e.Injection.Length != nil || e.Injection.Scratch, err
+	case len(e.SyscallHandlers) > 0:
+		// read straight from CurrentFuzzCase by the target so the size can change
+		return DefaultScratchSize, true, nil
+	}
+	e.addressesOfEgg, err = e.FindEgg(egg)
+	return len(egg), false, err
+}
+
+func (e *SnapshotExecutor) takeSnapshot() error {
+	if e.Loopback != nil {
+		// connect and send the payload while the tracee runs to the snapshot point
+		e.delivered = e.Loopback.Start(e.Pid, e.egg, false)
+	}
+	return e.TakeSnapshot()
+}
+
+func (e *SnapshotExecutor) Run() (ExecResult, error) {
+	if e.Injection != nil {
+		if err := e.InjectFuzzCase(); err != nil {
+			return ExecResult{}, err
+		}
+	} else if len(e.SyscallHandlers) == 0 {
+		// Write To Process Memory
+		for _, address := range e.addressesOfEgg {
+			if err := e.WriteBufferToProcess(address, e.CurrentFuzzCase); err != nil {
+				return ExecResult{}, err
+			}
+		}
+	}
+	before := e.Coverage.Hit
+	run, err := e.CoverageLoop()
+	if err != nil {
+		return ExecResult{}, err
+	}
+	return execResult(run, e.Coverage.Hit > before), nil
+}
+
+// Reset restores the snapshot, crashes and hangs included since the snapshot puts back the
+// registers and memory. A tracee that exited is replaced first
+func (e *SnapshotExecutor) Reset() error {
+	if e.Pid == 0 {
+		return e.respawn()
+	}
+	return e.RestoreSnapshot()
+}
+
+// respawn brings a new tracee into the snapshot the way a saved snapshot is resumed. A
+// loopback connection can't be put back so its snapshot is taken again instead, with the
+// egg found anew. The scratch buffer isn't part of the snapshot and is mapped again
+func (e *SnapshotExecutor) respawn() error {
+	if e.Loopback != nil {
+		// the old tracee is gone so a client still waiting on it gives up soon
+		if e.delivered != nil {
+			<-e.delivered
+		}
+		e.Loopback.Close()
+	}
+	if err := e.Spawn(e.TargetArgs(e.PayloadPath)); err != nil {
+		return err
+	}
+	var err error
+	if e.Loopback == nil {
+		err = e.ResumeSnapshot(e.SnapshotData)
+	} else if err = e.takeSnapshot(); err == nil && len(e.addressesOfEgg) > 0 {
+		e.addressesOfEgg, err = e.FindEgg(e.egg)
+	}
+	if err == nil && e.Injection != nil && e.Injection.Scratch {
+		e.Injection.ScratchAddress, err = e.AllocateScratch(e.Injection.MaxSize)
+	}
+	return err
+}
+
+func (e *SnapshotExecutor) Close() {
+	e.KillTracee()
+	if len(e.SyscallHandlers) == 0 {
+		os.Remove(e.PayloadPath)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"matcha/fuzzer/corpus"
+	"matcha/fuzzer/executor"
+	"matcha/fuzzer/mutator"
+	"matcha/internal/sanitizer"
+	"os"
+)
+
+// SpawnExecutor runs every case in a freshly spawned target. With a virtual file or network
+// the case is served from memory when the target reads its input instead of being written
+// to PayloadPath before every spawn
+type SpawnExecutor struct {
+	*State
+	PayloadPath string
+}
+
+// Prepare has nothing to start, cases never grow past the biggest corpus entry
+func (e *SpawnExecutor) Prepare() (int, bool, error) {
+	if e.Sequence {
+		return mutator.MaxSequenceSize, true, nil
+	}
+	return e.Corpus.BiggestCaseSize(), false, nil
+}
+
+func (e *SpawnExecutor) Run() (ExecResult, error) {
+	if len(e.SyscallHandlers) == 0 {
+		if err := corpus.WriteFuzzCaseToDisk(e.PayloadPath, e.CurrentFuzzCase); err != nil {
+			return ExecResult{}, err
+		}
+	}
+	before := e.Coverage.Hit
+	run, err := e.RunCase(e.PayloadPath)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	return execResult(run, e.Coverage.Hit > before), nil
+}
+
+// Reset kills whatever is left of the tracee, RunCase already did after a complete exec
+func (e *SpawnExecutor) Reset() error {
+	e.KillTracee()
+	return nil
+}
+
+func (e *SpawnExecutor) Close() {
+	e.KillTracee()
+	if len(e.SyscallHandlers) == 0 {
+		os.Remove(e.PayloadPath)
+	}
+}
+
+// execResult turns how the tracer saw the exec end into the outcome of the case. A target
+// exiting after a sanitizer report crashed as well, it was built not to abort
+func execResult(run executor.Result, newCoverage bool) ExecResult {
+	result := ExecResult{NewCoverage: newCoverage, Sanitizer: sanitizer.Parse(run.Stderr), Stderr: run.Stderr}
+	switch run.Outcome {
+	case executor.Crashed:
+		result.Outcome = CaseCrash
+		result.Signal = run.Signal
+		result.PC = run.PC
+		result.Fault = run.Fault
+	case executor.Hung:
+		result.Outcome = CaseHang
+	case executor.Exited:
+		result.Exited = true
+		if result.Sanitizer != nil {
+			result.Outcome = CaseCrash
+		}
+	}
+	return result
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ReportOptions say where and how often a campaign reports its progress
+type ReportOptions struct {
+	// StatsDir gets fuzzer_stats and plot_data, empty to write neither
+	StatsDir string
+	// Interval between console lines and stats file updates
+	Interval time.Duration
+	// Dashboard replaces the console lines with a full screen view when stdout is a terminal
+	Dashboard bool
+	// ControlAddress is where the HTTP metrics and control API listens, empty for none
+	ControlAddress string
+}
+
+// FuzzerStats is the fuzzer_stats file, rewritten every report interval for dashboards and
+// CI jobs to read. Times are unix seconds, LastFind is 0 until a case finds new coverage.
+// UniqueCrashes counts crash buckets, SavedCrashes the crashing inputs with distinct contents
+// saved in the crashes directory
+type FuzzerStats struct {
+	Target            string  `json:"target"`
+	Pid               int     `json:"fuzzer_pid"`
+	StartTime         int64   `json:"start_time"`
+	LastUpdate        int64   `json:"last_update"`
+	Uptime            float64 `json:"uptime_seconds"`
+	Execs             uint64  `json:"execs_done"`
+	ExecsPerSec       float64 `json:"execs_per_sec"`
+	RecentExecsPerSec float64 `json:"recent_execs_per_sec"`
+	BlocksHit         uint64  `json:"blocks_hit"`
+	BlocksTotal       uint64  `json:"blocks_total"`
+	Coverage          float64 `json:"coverage_percent"`
+	UniqueCrashes     int     `json:"unique_crashes"`
+	SavedCrashes      int     `json:"saved_crashes"`
+	TotalCrashes      uint64  `json:"total_crashes"`
+	Hangs             uint64  `json:"hangs"`
+	CorpusSize        int     `json:"corpus_size"`
+	LastFind          int64   `json:"last_find"`
+}
+
+const plotDataHeader = "unix_time,uptime_seconds,execs_done,execs_per_sec,blocks_hit,blocks_total,coverage_percent,unique_crashes,total_crashes,hangs,corpus_size,saved_crashes\n"
+
+func (s *State) Stats() FuzzerStats {
+	now := time.Now()
+	uptime := now.Sub(START_TIME).Seconds()
+	stats := FuzzerStats{
+		Target:        s.Path,
+		Pid:           os.Getpid(),
+		StartTime:     START_TIME.Unix(),
+		LastUpdate:    now.Unix(),
+		Uptime:        uptime,
+		Execs:         s.FuzzCases,
+		BlocksHit:     s.Coverage.Hit,
+		BlocksTotal:   s.Coverage.Total,
+		UniqueCrashes: len(s.CrashBuckets),
+		SavedCrashes:  len(s.Corpus.CrashHashes),
+		TotalCrashes:  s.Crashes,
+		Hangs:         s.Hangs,
+		CorpusSize:    s.Corpus.CorpusCount,
+	}
+	if uptime > 0 {
+		stats.ExecsPerSec = float64(s.FuzzCases) / uptime
+	}
+	if since := now.Sub(s.LastReport).Seconds(); !s.LastReport.IsZero() && since > 0 {
+		stats.RecentExecsPerSec = float64(s.FuzzCases-s.lastReportExecs) / since
+	} else {
+		stats.RecentExecsPerSec = stats.ExecsPerSec
+	}
+	if s.Coverage.Total > 0 {
+		stats.Coverage = float64(s.Coverage.Hit) / float64(s.Coverage.Total) * 100.0
+	}
+	if !s.LastNewFind.IsZero() {
+		stats.LastFind = s.LastNewFind.Unix()
+	}
+	return stats
+}
+
+// ReportPeriodically redraws the dashboard, and prints the stats line and updates the stats
+// files once the report interval has passed since the last report
+func (s *State) ReportPeriodically() {
+	if s.Dashboard != nil {
+		s.Dashboard.DrawPeriodically(s)
+	}
+	if time.Since(s.LastReport) < s.Report.Interval {
+		return
+	}
+	s.ReportStats()
+}
+
+// ReportStats prints the stats line, unless the dashboard shows them, and updates the stats
+// files, a failed write is reported and the campaign carries on
+func (s *State) ReportStats() {
+	stats := s.Stats()
+	if s.Dashboard == nil {
+		s.PrintStats()
+	}
+	if err := s.WriteStats(stats); err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: writing stats: %v\n", err)
+	}
+	s.LastReport = time.Now()
+	s.lastReportExecs = s.FuzzCases
+}
+
+// WriteStats replaces fuzzer_stats and appends a row to plot_data
+func (s *State) WriteStats(stats FuzzerStats) error {
+	if s.Report.StatsDir == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write then rename so readers never see half a file
+	path := filepath.Join(s.Report.StatsDir, "fuzzer_stats")
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	plot, err := openPlotData(filepath.Join(s.Report.StatsDir, "plot_data"))
+	if err != nil {
+		return err
+	}
+	defer plot.Close()
+	if info, err := plot.Stat(); err == nil && info.Size() == 0 {
+		plot.WriteString(plotDataHeader)
+	}
+	_, err = fmt.Fprintf(plot, "%d,%.0f,%d,%.2f,%d,%d,%.2f,%d,%d,%d,%d,%d\n", stats.LastUpdate, stats.Uptime, stats.Execs, stats.RecentExecsPerSec, stats.BlocksHit, stats.BlocksTotal, stats.Coverage, stats.UniqueCrashes, stats.TotalCrashes, stats.Hangs, stats.CorpusSize, stats.SavedCrashes)
+	return err
+}
+
+// openPlotData opens plot_data for appending. A plot_data with other columns, left by an
+// older version, is moved aside to plot_data.<unix time> so the rows of a file all match
+// its header
+func openPlotData(path string) (*os.File, error) {
+	if f, err := os.Open(path); err == nil {
+		header, _ := bufio.NewReader(f).ReadString('\n')
+		f.Close()
+		if header != "" && header != plotDataHeader {
+			old := fmt.Sprintf("%s.%d", path, time.Now().Unix())
+			if err := os.Rename(path, old); err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(os.Stderr, "INFO: plot_data has other columns, moved it to %s\n", old)
+		}
+	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
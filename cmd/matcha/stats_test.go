@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenPlotData(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     string
+		rotated bool
+	}{
+		{"new file", "", false},
+		{"same columns", plotDataHeader + "1,2,3\n", false},
+		{"older columns", "unix_time,uptime_seconds\n1,2\n", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "plot_data")
+			if test.old != "" {
+				if err := os.WriteFile(path, []byte(test.old), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			plot, err := openPlotData(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plot.Close()
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rotated := len(entries) == 2; rotated != test.rotated {
+				t.Errorf("rotated = %v, want %v, dir has %d files", rotated, test.rotated, len(entries))
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := test.old
+			if test.rotated {
+				want = ""
+			}
+			if string(data) != want {
+				t.Errorf("plot_data = %q, want %q", data, want)
+			}
+		})
+	}
+}
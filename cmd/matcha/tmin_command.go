@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// TminCommand shrinks an input while it keeps behaving the same, a crash has to keep its
+// signal and faulting pc and anything else has to hit the same blocks. Chunks are deleted
+// from half the input down to single bytes, then bytes are normalized to a fixed value
+//
+//	matcha tmin -i crashes/<md5>.bin -o crash.min -target ./jsonlint -blocks ./libjson_blocks.txt
+func TminCommand(args []string) {
+	fs := flag.NewFlagSet("tmin", flag.ExitOnError)
+	inputPtr := fs.String("i", "", "input to minimize")
+	outputPtr := fs.String("o", "", "path to write the minimized input to")
+	fillPtr := fs.String("fill", "0x30", "byte value the remaining bytes are normalized to")
+	options := addTargetFlags(fs)
+	if err := options.Parse(fs, args); err != nil {
+		log.Fatal(err)
+	}
+	if *inputPtr == "" || *outputPtr == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	fill, err := strconv.ParseUint(*fillPtr, 0, 8)
+	if err != nil {
+		log.Fatalf("bad fill byte %s", *fillPtr)
+	}
+	data, err := os.ReadFile(*inputPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	s, err := options.NewTracingState()
+	if err != nil {
+		log.Fatal(err)
+	}
+	payloadPath := s.PayloadPath(filepath.Dir(*outputPtr))
+	expected, err := s.TraceCase(data, payloadPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if expected.Sanitizer != nil {
+		fmt.Printf("INFO: Keeping Crash %s\n", expected.Sanitizer.Bucket())
+	} else if expected.Crashed() {
+		fmt.Printf("INFO: Keeping Crash %s At 0x%x\n", expected.Signal, expected.PC)
+	} else {
+		fmt.Printf("INFO: Keeping Coverage Of %d Blocks\n", len(expected.Blocks))
+	}
+	execs := 0
+	same := func(candidate []byte) bool {
+		execs++
+		result, err := s.TraceCase(candidate, payloadPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return expected.SameBehavior(result)
+	}
+	minimized := ShrinkInput(data, byte(fill), same)
+	if s.VirtualFile == nil {
+		os.Remove(payloadPath)
+	}
+	if err := os.WriteFile(*outputPtr, minimized, 0644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("INFO: Minimized %d To %d Bytes In %d Execs\n", len(data), len(minimized), execs)
+}
+
+// ShrinkInput deletes chunks of data at halving sizes and then sets single bytes to fill,
+// a change is only kept when same accepts it
+func ShrinkInput(data []byte, fill byte, same func([]byte) bool) []byte {
+	data = append([]byte(nil), data...)
+	for chunk := len(data) / 2; chunk >= 1; chunk /= 2 {
+		for start := 0; start+chunk <= len(data); {
+			candidate := append(append([]byte(nil), data[:start]...), data[start+chunk:]...)
+			if same(candidate) {
+				data = candidate
+				continue
+			}
+			start += chunk
+		}
+	}
+	for i := range data {
+		if data[i] == fill {
+			continue
+		}
+		original := data[i]
+		data[i] = fill
+		if !same(data) {
+			data[i] = original
+		}
+	}
+	return data
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"matcha/internal/sanitizer"
+	"syscall"
+	"testing"
+)
+
+func TestShrinkInput(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		same func([]byte) bool
+		want string
+	}{
+		{"empty input", "", func([]byte) bool { return true }, ""},
+		{"anything goes", "abcdef", func([]byte) bool { return true }, ""},
+		{"nothing goes", "abcdef", func([]byte) bool { return false }, "abcdef"},
+		{"keeps the needle", "xxxxBUGyyyyyyy", func(data []byte) bool { return bytes.Contains(data, []byte("BUG")) }, "BUG"},
+		// the length survives deletion so every byte is normalized
+		{"normalizes bytes", "abcd", func(data []byte) bool { return len(data) == 4 }, "0000"},
+		{"keeps bytes that matter", "abcd", func(data []byte) bool { return len(data) == 4 && data[2] == 'c' }, "00c0"},
+		{"odd length", "aaBaaaa", func(data []byte) bool { return bytes.IndexByte(data, 'B') >= 0 }, "B"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := []byte(test.data)
+			got := ShrinkInput(data, '0', test.same)
+			if string(got) != test.want {
+				t.Errorf("ShrinkInput = %q, want %q", got, test.want)
+			}
+			if string(data) != test.data {
+				t.Errorf("ShrinkInput changed its input to %q", data)
+			}
+		})
+	}
+}
+
+func TestSameBehavior(t *testing.T) {
+	asan := func(bucket string) *sanitizer.Report {
+		return &sanitizer.Report{Tool: "AddressSanitizer", Type: bucket}
+	}
+	tests := []struct {
+		name     string
+		expected CaseResult
+		result   CaseResult
+		want     bool
+	}{
+		{"same blocks", CaseResult{Blocks: []uint64{1, 2}}, CaseResult{Blocks: []uint64{1, 2}}, true},
+		{"other blocks", CaseResult{Blocks: []uint64{1, 2}}, CaseResult{Blocks: []uint64{1}}, false},
+		{"crash instead of blocks", CaseResult{Blocks: []uint64{1}}, CaseResult{Blocks: []uint64{1}, Signal: syscall.SIGSEGV}, false},
+		{"same crash other blocks", CaseResult{Signal: syscall.SIGSEGV, PC: 0x401000, Blocks: []uint64{1}}, CaseResult{Signal: syscall.SIGSEGV, PC: 0x401000}, true},
+		{"other pc", CaseResult{Signal: syscall.SIGSEGV, PC: 0x401000}, CaseResult{Signal: syscall.SIGSEGV, PC: 0x401004}, false},
+		{"other signal", CaseResult{Signal: syscall.SIGSEGV, PC: 0x401000}, CaseResult{Signal: syscall.SIGABRT, PC: 0x401000}, false},
+		{"no crash", CaseResult{Signal: syscall.SIGSEGV, PC: 0x401000}, CaseResult{}, false},
+		{"same sanitizer report", CaseResult{Sanitizer: asan("heap-use-after-free")}, CaseResult{Signal: syscall.SIGABRT, Sanitizer: asan("heap-use-after-free")}, true},
+		{"other sanitizer report", CaseResult{Sanitizer: asan("heap-use-after-free")}, CaseResult{Sanitizer: asan("double-free")}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.expected.SameBehavior(test.result); got != test.want {
+				t.Errorf("SameBehavior = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
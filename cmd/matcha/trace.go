@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"matcha/fuzzer/corpus"
+	"matcha/fuzzer/executor"
+	"matcha/internal/sanitizer"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// CaseResult is what a single run of an input did, the blocks it hit and the signal and pc
+// it crashed with, Signal is 0 when the target did not crash. Sanitizer is the report a
+// sanitizer build wrote, whether it aborted or exited after it
+type CaseResult struct {
+	Blocks    []uint64
+	Signal    syscall.Signal
+	PC        uint64
+	Sanitizer *sanitizer.Report
+}
+
+func (r CaseResult) Crashed() bool {
+	return r.Signal != 0 || r.Sanitizer != nil
+}
+
+// SameCrash reports whether other crashed the same way, by the sanitizer bucket when there
+// is a report like CrashBucket and by signal and pc otherwise
+func (r CaseResult) SameCrash(other CaseResult) bool {
+	if r.Sanitizer != nil {
+		return other.Sanitizer != nil && other.Sanitizer.Bucket() == r.Sanitizer.Bucket()
+	}
+	return other.Sanitizer == nil && other.Signal == r.Signal && other.PC == r.PC
+}
+
+// SameBehavior reports whether other behaved like r as tmin sees it, a crash has to crash
+// the same way and anything else has to hit the same blocks without crashing
+func (r CaseResult) SameBehavior(other CaseResult) bool {
+	if r.Crashed() {
+		return r.SameCrash(other)
+	}
+	return !other.Crashed() && slices.Equal(other.Blocks, r.Blocks)
+}
+
+// TargetArgs are the arguments the target is spawned with, Args with @@ replaced by the
+// path the case is written to
+func (s *State) TargetArgs(payloadPath string) []string {
+	args := make([]string, len(s.Args))
+	for i, arg := range s.Args {
+		args[i] = strings.ReplaceAll(arg, "@@", payloadPath)
+	}
+	return args
+}
+
+// TraceCase runs data once in a fresh tracee with every breakpoint set. Nothing is written
+// to the corpus or crashes directories, the tools built on it decide what to keep
+func (s *State) TraceCase(data []byte, payloadPath string) (CaseResult, error) {
+	var result CaseResult
+	s.CurrentFuzzCase = append(s.CurrentFuzzCase[:0], data...)
+	if len(s.SyscallHandlers) == 0 {
+		if err := corpus.WriteFuzzCaseToDisk(payloadPath, s.CurrentFuzzCase); err != nil {
+			return result, err
+		}
+	}
+	s.Coverage.Reset()
+	run, err := s.RunCase(payloadPath)
+	if err != nil {
+		return result, err
+	}
+	if run.Outcome == executor.Crashed {
+		result.Signal = run.Signal
+		result.PC = run.PC
+	}
+	result.Sanitizer = sanitizer.Parse(run.Stderr)
+	result.Blocks = s.Coverage.HitBlocks()
+	return result, nil
+}
+
+// targetOptions are the flags the corpus tools share with the fuzzer to run the target
+// the same way it was fuzzed
+type targetOptions struct {
+	state           *string
+	target          *string
+	targetArgs      *string
+	base            *uint64
+	blocks          *string
+	virtualFile     *string
+	network         *bool
+	framed          *bool
+	loopback        *string
+	loopbackPort    *int
+	loopbackTimeout *time.Duration
+}
+
+func addTargetFlags(fs *flag.FlagSet) *targetOptions {
+	return &targetOptions{
+		state:           fs.String("state", "", "state file of a campaign, the target is run with the flags it was fuzzed with unless they are given"),
+		target:          fs.String("target", "./jsonlint", "path of the target binary"),
+		targetArgs:      fs.String("target-args", "@@ --tree", "arguments of the target, @@ is replaced by the path of the input"),
+		base:            fs.Uint64("base", 0x400000, "base address of the target"),
+		blocks:          fs.String("blocks", "./libjson_blocks.txt", "file of basic block offsets to instrument"),
+		virtualFile:     fs.String("virtual-file", "", "serve inputs from memory when the target opens this path"),
+		network:         fs.Bool("network", false, "emulate the target's inet sockets"),
+		framed:          fs.Bool("framed", false, "inputs are packets prefixed by a big endian u16 length"),
+		loopback:        fs.String("loopback", "", "send inputs over tcp or udp on 127.0.0.1"),
+		loopbackPort:    fs.Int("loopback-port", 0, "port to connect to (loopback)"),
+		loopbackTimeout: fs.Duration("loopback-timeout", time.Second, "how long to wait for the target (loopback)"),
+	}
+}
+
+// Parse parses args into fs and fills the flags not given from the -state file
+func (o *targetOptions) Parse(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	if *o.state == "" {
+		return nil
+	}
+	return ApplyCampaignFlags(fs, *o.state)
+}
+
+// NewTracingState builds a State ready for TraceCase from the parsed flags
+func (o *targetOptions) NewTracingState() (*State, error) {
+	s, err := NewState(*o.target, *o.base, 0x0, 0x0)
+	if err != nil {
+		return nil, err
+	}
+	return s, o.Setup(s, InputOptions{})
+}
+
+// Setup adds the input delivery of the parsed flags to input and loads the blocks
+func (o *targetOptions) Setup(s *State, input InputOptions) error {
+	input.Args = strings.Fields(*o.targetArgs)
+	input.VirtualFile, input.Network, input.Framed = *o.virtualFile, *o.network, *o.framed
+	if *o.loopback != "" {
+		var err error
+		input.Loopback, err = NewLoopbackClient(*o.loopback, *o.loopbackPort, *o.framed, 0, *o.loopbackTimeout)
+		if err != nil {
+			return err
+		}
+	}
+	if err := s.SetupInput(input); err != nil {
+		return err
+	}
+	return s.LoadBlocks(*o.blocks)
+}
+
+// PayloadPath is where inputs are written for the target to read, the virtual file when
+// there is one
+func (s *State) PayloadPath(dir string) string {
+	if s.VirtualFile != nil {
+		return s.VirtualFile.Path
+	}
+	return fmt.Sprintf("%s/tmp.bin", dir)
+}
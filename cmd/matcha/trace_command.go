@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"matcha/internal/blocktrace"
+	"os"
+)
+
+func traceUsage() {
+	fmt.Fprintln(os.Stderr, "usage: matcha trace dump [-target <binary>] <trace>")
+	fmt.Fprintln(os.Stderr, "       matcha trace diff [-target <binary>] [-context <n>] <trace> <trace>")
+	os.Exit(2)
+}
+
+// TraceCommand reads the traces written by matcha replay -trace. diff shows where two inputs
+// took different paths and exits with 1 when they did
+//
+//	matcha replay -target ./exif -blocks ./exif_blocks.txt -trace crash.trace crashes/<md5>.bin
+//	matcha replay -target ./exif -blocks ./exif_blocks.txt -trace min.trace crash.min
+//	matcha trace diff -target ./exif crash.trace min.trace
+func TraceCommand(args []string) {
+	if len(args) < 1 {
+		traceUsage()
+	}
+	fs := flag.NewFlagSet("trace "+args[0], flag.ExitOnError)
+	targetPtr := fs.String("target", "", "binary the traces were recorded from, to name functions and source lines")
+	contextPtr := fs.Int("context", 5, "common blocks shown before the split (diff)")
+	fs.Parse(args[1:])
+	var image *CoverageImage
+	if *targetPtr != "" {
+		var err error
+		if image, err = LoadCoverageImage(*targetPtr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	switch {
+	case args[0] == "dump" && fs.NArg() == 1:
+		entries, err := blocktrace.Load(fs.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, entry := range entries {
+			printTraceEntry("", entry, image)
+		}
+	case args[0] == "diff" && fs.NArg() == 2:
+		a, err := blocktrace.Load(fs.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		b, err := blocktrace.Load(fs.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !DiffTraces(a, b, fs.Arg(0), fs.Arg(1), *contextPtr, image) {
+			os.Exit(1)
+		}
+	default:
+		traceUsage()
+	}
+}
+
+// DiffTraces prints the blocks leading up to where a and b split and the first blocks of
+// each after it, true when they are the same
+func DiffTraces(a []blocktrace.Entry, b []blocktrace.Entry, nameA string, nameB string, context int, image *CoverageImage) bool {
+	split := blocktrace.Split(a, b)
+	if split < 0 {
+		fmt.Printf("INFO: Same %d Blocks\n", len(a))
+		return true
+	}
+	fmt.Printf("INFO: Traces Split After %d Common Blocks\n", split)
+	for _, entry := range a[max(0, split-context):split] {
+		printTraceEntry("  ", entry, image)
+	}
+	for _, side := range []struct {
+		name    string
+		entries []blocktrace.Entry
+	}{{nameA, a}, {nameB, b}} {
+		fmt.Printf("%s (%d blocks)\n", side.name, len(side.entries))
+		if split == len(side.entries) {
+			fmt.Println("- ends here")
+			continue
+		}
+		for _, entry := range side.entries[split:min(len(side.entries), split+context)] {
+			printTraceEntry("- ", entry, image)
+		}
+	}
+	return false
+}
+
+func printTraceEntry(prefix string, entry blocktrace.Entry, image *CoverageImage) {
+	description := ""
+	if image != nil {
+		description = image.Describe(entry.Offset)
+	}
+	fmt.Printf("%s%8d %12s 0x%x %s\n", prefix, entry.Counter, entry.Time, entry.Offset, description)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTargetArgs(t *testing.T) {
+	tests := []struct {
+		args string
+		want []string
+	}{
+		{"@@ --tree", []string{"/tmp/tmp.bin", "--tree"}},
+		{"-d --input=@@", []string{"-d", "--input=/tmp/tmp.bin"}},
+		{"--listen 8080", []string{"--listen", "8080"}},
+		{"", []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.args, func(t *testing.T) {
+			s := &State{Args: strings.Fields(test.args)}
+			if got := s.TargetArgs("/tmp/tmp.bin"); !slices.Equal(got, test.want) {
+				t.Errorf("TargetArgs = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"matcha/fuzzer/executor"
+	"matcha/fuzzer/ptrace"
+	"matcha/internal/snapshot"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	pendingNone = iota
+	pendingResult
+	pendingOpen
+	pendingStat
+	pendingMmap
+	pendingDup
+)
+
+// offsets into the x86_64 struct stat
+const (
+	statModeOffset    = 24
+	statRdevOffset    = 40
+	statSizeOffset    = 48
+	statBlkSizeOffset = 56
+	statBlocksOffset  = 64
+)
+
+const (
+	atFdCwd     = -100
+	atEmptyPath = 0x1000
+)
+
+// VirtualFile serves the current fuzz case to the tracee whenever it opens Path, without the
+// case ever touching disk. Every syscall is stopped with PTRACE_SYSCALL, opens of Path are
+// redirected to /dev/null so the kernel hands out a real fd, and reads, seeks, stats and
+// mmaps on those fds are answered from CurrentFuzzCase. Fds duplicated with dup, dup2, dup3
+// or fcntl F_DUPFD share the offset of the fd they were duplicated from
+type VirtualFile struct {
+	Path      string
+	Fds       map[int]*int64
+	savedFds  map[int]*int64
+	inSyscall bool
+	pending   pendingSyscall
+}
+
+type pendingSyscall struct {
+	kind    int
+	result  int64
+	address uint64
+	offset  int64
+	length  uint64
+	fd      int
+}
+
+func NewVirtualFile(path string) (*VirtualFile, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return &VirtualFile{Path: abs, Fds: make(map[int]*int64)}, nil
+}
+
+func (v *VirtualFile) Attach(pid int) error {
+	if err := ptrace.SetOptions(pid, syscall.PTRACE_O_TRACESYSGOOD); err != nil {
+		return err
+	}
+	v.Fds = make(map[int]*int64)
+	v.inSyscall = false
+	return nil
+}
+
+func (v *VirtualFile) Save() {
+	v.savedFds = copyFds(v.Fds)
+}
+
+func (v *VirtualFile) Restore() {
+	v.Fds = copyFds(v.savedFds)
+	v.inSyscall = false
+}
+
+// copyFds copies the offsets, fds sharing one keep sharing the copy
+func copyFds(fds map[int]*int64) map[int]*int64 {
+	copied := make(map[int]*int64)
+	offsets := make(map[*int64]*int64)
+	for fd, offset := range fds {
+		if offsets[offset] == nil {
+			value := *offset
+			offsets[offset] = &value
+		}
+		copied[fd] = offsets[offset]
+	}
+	return copied
+}
+
+func (v *VirtualFile) HandleSyscall(e *executor.Executor) error {
+	regs, err := ptrace.GetRegs(e.Pid)
+	if err != nil {
+		return err
+	}
+	if !v.inSyscall {
+		v.inSyscall = true
+		v.pending = pendingSyscall{}
+		return v.enter(e, &regs)
+	}
+	v.inSyscall = false
+	return v.exit(e, &regs)
+}
+
+func (v *VirtualFile) enter(e *executor.Executor, regs *syscall.PtraceRegs) error {
+	data := e.CurrentFuzzCase
+	switch regs.Orig_rax {
+	case syscall.SYS_OPEN:
+		redirected, err := v.redirectPath(e, atFdCwd, &regs.Rdi)
+		if !redirected {
+			return err
+		}
+		v.pending.kind = pendingOpen
+		return ptrace.SetRegs(e.Pid, *regs)
+	case syscall.SYS_OPENAT:
+		redirected, err := v.redirectPath(e, int(int32(regs.Rdi)), &regs.Rsi)
+		if !redirected {
+			return err
+		}
+		v.pending.kind = pendingOpen
+		return ptrace.SetRegs(e.Pid, *regs)
+	case syscall.SYS_READ, syscall.SYS_PREAD64:
+		shared, ok := v.Fds[int(regs.Rdi)]
+		if !ok {
+			return nil
+		}
+		offset := *shared
+		if regs.Orig_rax == syscall.SYS_PREAD64 {
+			offset = int64(regs.R10)
+		}
+		n := 0
+		if offset >= 0 && offset < int64(len(data)) {
+			n = len(data) - int(offset)
+			if uint64(n) > regs.Rdx {
+				n = int(regs.Rdx)
+			}
+			if err := e.WriteBufferToProcess(regs.Rsi, data[offset:offset+int64(n)]); err != nil {
+				return err
+			}
+		}
+		if regs.Orig_rax == syscall.SYS_READ {
+			*shared = offset + int64(n)
+		}
+		return v.skip(e, regs, int64(n))
+	case syscall.SYS_LSEEK:
+		shared, ok := v.Fds[int(regs.Rdi)]
+		if !ok {
+			return nil
+		}
+		offset := *shared
+		switch regs.Rdx {
+		case 0:
+			offset = int64(regs.Rsi)
+		case 1:
+			offset += int64(regs.Rsi)
+		case 2:
+			offset = int64(len(data)) + int64(regs.Rsi)
+		default:
+			return v.skip(e, regs, -int64(syscall.EINVAL))
+		}
+		if offset < 0 {
+			return v.skip(e, regs, -int64(syscall.EINVAL))
+		}
+		*shared = offset
+		return v.skip(e, regs, offset)
+	case syscall.SYS_FSTAT:
+		if _, ok := v.Fds[int(regs.Rdi)]; ok {
+			v.pending = pendingSyscall{kind: pendingStat, address: regs.Rsi}
+		}
+	case syscall.SYS_STAT, syscall.SYS_LSTAT:
+		redirected, err := v.redirectPath(e, atFdCwd, &regs.Rdi)
+		if !redirected {
+			return err
+		}
+		v.pending = pendingSyscall{kind: pendingStat, address: regs.Rsi}
+		return ptrace.SetRegs(e.Pid, *regs)
+	case syscall.SYS_NEWFSTATAT:
+		_, virtualFd := v.Fds[int(int32(regs.Rdi))]
+		if virtualFd && regs.R10&atEmptyPath != 0 {
+			v.pending = pendingSyscall{kind: pendingStat, address: regs.Rdx}
+			return nil
+		}
+		redirected, err := v.redirectPath(e, int(int32(regs.Rdi)), &regs.Rsi)
+		if !redirected {
+			return err
+		}
+		v.pending = pendingSyscall{kind: pendingStat, address: regs.Rdx}
+		return ptrace.SetRegs(e.Pid, *regs)
+	case syscall.SYS_MMAP:
+		if _, ok := v.Fds[int(int32(regs.R8))]; !ok {
+			return nil
+		}
+		// back the mapping with anonymous memory and copy the case in on exit
+		v.pending = pendingSyscall{kind: pendingMmap, offset: int64(regs.R9), length: regs.Rsi}
+		regs.R10 = (regs.R10|syscall.MAP_ANONYMOUS)&^syscall.MAP_SHARED | syscall.MAP_PRIVATE
+		regs.R8 = ^uint64(0)
+		regs.R9 = 0
+		return ptrace.SetRegs(e.Pid, *regs)
+	case syscall.SYS_DUP:
+		if _, ok := v.Fds[int(regs.Rdi)]; ok {
+			v.pending = pendingSyscall{kind: pendingDup, fd: int(regs.Rdi)}
+		}
+	case syscall.SYS_DUP2, syscall.SYS_DUP3:
+		// a virtual new fd is closed by the dup even when the old one isn't virtual
+		_, oldVirtual := v.Fds[int(regs.Rdi)]
+		_, newVirtual := v.Fds[int(regs.Rsi)]
+		if oldVirtual || newVirtual {
+			v.pending = pendingSyscall{kind: pendingDup, fd: int(regs.Rdi)}
+		}
+	case syscall.SYS_FCNTL:
+		if _, ok := v.Fds[int(regs.Rdi)]; ok && (regs.Rsi == syscall.F_DUPFD || regs.Rsi == syscall.F_DUPFD_CLOEXEC) {
+			v.pending = pendingSyscall{kind: pendingDup, fd: int(regs.Rdi)}
+		}
+	case syscall.SYS_CLOSE:
+		delete(v.Fds, int(regs.Rdi))
+	}
+	return nil
+}
+
+func (v *VirtualFile) exit(e *executor.Executor, regs *syscall.PtraceRegs) error {
+	ret := int64(regs.Rax)
+	switch v.pending.kind {
+	case pendingResult:
+		regs.Rax = uint64(v.pending.result)
+		return ptrace.SetRegs(e.Pid, *regs)
+	case pendingOpen:
+		if ret >= 0 {
+			v.Fds[int(ret)] = new(int64)
+		}
+	case pendingDup:
+		if ret < 0 {
+			return nil
+		}
+		if shared, ok := v.Fds[v.pending.fd]; ok {
+			v.Fds[int(ret)] = shared
+		} else {
+			delete(v.Fds, int(ret))
+		}
+	case pendingStat:
+		if ret != 0 {
+			return nil
+		}
+		size := uint64(len(e.CurrentFuzzCase))
+		fields := []struct {
+			offset uint64
+			value  uint64
+			width  int
+		}{
+			{statModeOffset, syscall.S_IFREG | 0644, 4},
+			{statRdevOffset, 0, 8},
+			{statSizeOffset, size, 8},
+			{statBlkSizeOffset, 4096, 8},
+			{statBlocksOffset, (size + 511) / 512, 8},
+		}
+		field := make([]byte, 8)
+		for _, f := range fields {
+			binary.LittleEndian.PutUint64(field, f.value)
+			if err := e.WriteBufferToProcess(v.pending.address+f.offset, field[:f.width]); err != nil {
+				return err
+			}
+		}
+	case pendingMmap:
+		if ret < 0 && ret > -4096 {
+			return nil
+		}
+		data := e.CurrentFuzzCase
+		if v.pending.offset >= int64(len(data)) {
+			return nil
+		}
+		data = data[v.pending.offset:]
+		if uint64(len(data)) > v.pending.length {
+			data = data[:v.pending.length]
+		}
+		return e.WriteBufferToProcess(uint64(ret), data)
+	}
+	return nil
+}
+
+// skip stops the kernel from running the syscall and sets its result on exit
+func (v *VirtualFile) skip(e *executor.Executor, regs *syscall.PtraceRegs, result int64) error {
+	v.pending = pendingSyscall{kind: pendingResult, result: result}
+	return executor.SkipSyscall(e.Pid, regs)
+}
+
+// redirectPath points a path argument at /dev/null when it names the virtual file. A
+// relative path is resolved against dirfd like the *at syscalls do, or the cwd for
+// AT_FDCWD. A path that can't be read is left to the kernel, which fails the syscall with
+// EFAULT itself, and so is a dirfd that isn't open
+func (v *VirtualFile) redirectPath(e *executor.Executor, dirfd int, reg *uint64) (bool, error) {
+	path, err := ptrace.ReadString(e.Pid, *reg)
+	if err != nil || path == "" {
+		return false, nil
+	}
+	if !filepath.IsAbs(path) {
+		dir := fmt.Sprintf("/proc/%d/cwd", e.Pid)
+		if dirfd != atFdCwd {
+			dir = fmt.Sprintf("/proc/%d/fd/%d", e.Pid, dirfd)
+		}
+		base, err := os.Readlink(dir)
+		if err != nil && dirfd != atFdCwd {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		path = filepath.Join(base, path)
+	}
+	if filepath.Clean(path) != v.Path {
+		return false, nil
+	}
+	*reg, err = snapshot.WriteScratchString(e.Pid, os.DevNull)
+	return err == nil, err
+}
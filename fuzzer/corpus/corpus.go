@@ -0,0 +1,106 @@
+// Package corpus keeps the inputs worth fuzzing in memory and on disk, along with the
+// crashes found, deduplicated by the md5 of the input
+package corpus
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrEmpty is returned when the corpus directory holds no inputs to start from
+var ErrEmpty = errors.New("corpus is empty")
+
+type Corpus struct {
+	CorpusBuffers [][]byte
+	CorpusDir     string
+	CrashDir      string
+	CorpusCount   int
+	CrashHashes   map[string]bool
+}
+
+// Load reads every file of corpusDir, crashes are written to crashDir
+func Load(corpusDir string, crashDir string) (*Corpus, error) {
+	c := &Corpus{CorpusDir: corpusDir, CrashDir: crashDir, CrashHashes: make(map[string]bool)}
+	entry, err := os.ReadDir(corpusDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entry {
+		if e.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(corpusDir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		c.CorpusBuffers = append(c.CorpusBuffers, content)
+		c.CorpusCount++
+	}
+	if c.CorpusCount == 0 {
+		return nil, fmt.Errorf("%s: %w", corpusDir, ErrEmpty)
+	}
+	return c, nil
+}
+
+func (c *Corpus) GetCaseByIdx(idx int) []byte {
+	return c.CorpusBuffers[idx]
+}
+
+func (c *Corpus) BiggestCaseIdx() int {
+	biggest := 0
+	for i := range c.CorpusBuffers {
+		if len(c.CorpusBuffers[i]) > len(c.CorpusBuffers[biggest]) {
+			biggest = i
+		}
+	}
+	return biggest
+}
+
+func (c *Corpus) BiggestCaseSize() int {
+	return len(c.CorpusBuffers[c.BiggestCaseIdx()])
+}
+
+func WriteFuzzCaseToDisk(path string, buffer []byte) error {
+	return os.WriteFile(path, buffer, 0644)
+}
+
+func (c *Corpus) AddToCorpus(data []byte) error {
+	// data is usually the reused fuzz case buffer so keep our own copy
+	data = append([]byte(nil), data...)
+	c.CorpusBuffers = append(c.CorpusBuffers, data)
+	c.CorpusCount++
+	return os.WriteFile(filepath.Join(c.CorpusDir, fmt.Sprintf("%d.bin", c.CorpusCount)), data, 0644)
+}
+
+// KnownCrash reports whether a crashing input was saved already
+func (c *Corpus) KnownCrash(data []byte) bool {
+	return c.CrashHashes[crashName(data)]
+}
+
+// WriteCrashToDisk saves a crashing input named after its md5 in dir, a directory of the
+// crashes directory, with what is known about the crash next to it as <md5>.txt. Inputs
+// already saved are skipped and reported as not new
+func (c *Corpus) WriteCrashToDisk(data []byte, dir string, report string) (bool, error) {
+	name := crashName(data)
+	if c.CrashHashes[name] {
+		return false, nil
+	}
+	c.CrashHashes[name] = true
+	dir = filepath.Join(c.CrashDir, dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return true, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".bin"), data, 0644); err != nil {
+		return true, err
+	}
+	return true, os.WriteFile(filepath.Join(dir, name+".txt"), []byte(report), 0644)
+}
+
+func crashName(data []byte) string {
+	hash := md5.Sum(data)
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,125 @@
+// Package coverage tracks basic block coverage with one shot int3 breakpoints. Every block
+// gets a breakpoint, the first hit removes it for good so later execs run at full speed
+// through code that was already seen
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"matcha/fuzzer/ptrace"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// UnknownBreakPointError is a SIGTRAP at an address that holds none of our breakpoints
+type UnknownBreakPointError struct {
+	PC uint64
+}
+
+func (e *UnknownBreakPointError) Error() string {
+	return fmt.Sprintf("not a breakpoint 0x%x", e.PC)
+}
+
+type Map struct {
+	// Addresses are every block of the blocks file rebased
+	Addresses []uint64
+	// BreakPoints are the blocks not hit yet with the byte their int3 replaced
+	BreakPoints map[uint64][]byte
+	Total       uint64
+	Hit         uint64
+	// Skip are blocks hit before a resume, they count towards Total but are never set
+	Skip map[uint64]bool
+}
+
+// LoadBlocks reads a file of hex block offsets, one per line, and adds baseAddress
+func LoadBlocks(path string, baseAddress uint64) ([]uint64, error) {
+	bpFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer bpFile.Close()
+	bps := make([]uint64, 0)
+	scanner := bufio.NewScanner(bpFile)
+	for scanner.Scan() {
+		text := strings.Replace(strings.TrimSpace(scanner.Text()), "0x", "", -1)
+		if text == "" {
+			continue
+		}
+		offset, err := strconv.ParseUint(text, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		bps = append(bps, baseAddress+offset)
+	}
+	return bps, scanner.Err()
+}
+
+func NewMap(addresses []uint64) *Map {
+	return &Map{Addresses: addresses, BreakPoints: make(map[uint64][]byte)}
+}
+
+// Reset forgets every hit, the next Instrument starts from scratch
+func (m *Map) Reset() {
+	m.BreakPoints = make(map[uint64][]byte)
+	m.Total = 0
+	m.Hit = 0
+}
+
+// Instrument sets breakpoints in a tracee. The first call sets one on every block, later
+// calls only put back the ones not hit yet, for tracees spawned fresh from the binary
+func (m *Map) Instrument(pid int) error {
+	if m.Total == 0 {
+		for _, breakPoint := range m.Addresses {
+			m.Total++
+			if m.Skip[breakPoint] {
+				continue
+			}
+			originalBytes, err := ptrace.SetBP(pid, breakPoint)
+			if err != nil {
+				return err
+			}
+			m.BreakPoints[breakPoint] = originalBytes
+		}
+		return nil
+	}
+	for address := range m.BreakPoints {
+		if _, err := ptrace.SetBP(pid, address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HitBreakPoint removes the breakpoint at pc, the tracee has to be stopped right after it
+func (m *Map) HitBreakPoint(pid int, pc uint64) error {
+	originalBytes, ok := m.BreakPoints[pc]
+	if !ok {
+		return &UnknownBreakPointError{PC: pc}
+	}
+	if err := ptrace.DelBP(pid, pc, originalBytes); err != nil {
+		return err
+	}
+	if err := ptrace.SubRip(pid); err != nil {
+		return err
+	}
+	delete(m.BreakPoints, pc)
+	m.Hit++
+	return nil
+}
+
+// HitBlocks are the blocks whose breakpoint is gone, before the first Instrument only the
+// skipped ones
+func (m *Map) HitBlocks() []uint64 {
+	hit := make([]uint64, 0)
+	for _, address := range m.Addresses {
+		if m.Total == 0 {
+			if m.Skip[address] {
+				hit = append(hit, address)
+			}
+		} else if _, ok := m.BreakPoints[address]; !ok {
+			hit = append(hit, address)
+		}
+	}
+	return hit
+}
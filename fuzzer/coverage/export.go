@@ -0,0 +1,112 @@
+package coverage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+)
+
+// Block is a basic block by its offset from the image base. Size runs up to the next block
+// or the end of the function, Hits counts the inputs that reached it
+type Block struct {
+	Offset uint64
+	Size   uint64
+	Hits   uint64
+}
+
+// Module is the image blocks are exported for, as drcov lists it
+type Module struct {
+	Path string
+	Base uint64
+	Size uint64
+}
+
+// biggest block size a drcov entry holds
+const maxDrcovBlockSize = 0xffff
+
+// SizeBlocks sorts offsets and gives each block the size up to the next one or to limit,
+// which returns where the code containing an offset ends or 0 when it doesn't know
+func SizeBlocks(offsets []uint64, hits map[uint64]uint64, limit func(offset uint64) uint64) []Block {
+	sorted := append([]uint64(nil), offsets...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	sorted = slices.Compact(sorted)
+	blocks := make([]Block, 0, len(sorted))
+	for i, offset := range sorted {
+		end := limit(offset)
+		if i+1 < len(sorted) && (end == 0 || sorted[i+1] < end) {
+			end = sorted[i+1]
+		}
+		size := uint64(1)
+		if end > offset {
+			size = end - offset
+		}
+		blocks = append(blocks, Block{Offset: offset, Size: size, Hits: hits[offset]})
+	}
+	return blocks
+}
+
+// WriteDrcov writes the hit blocks as a drcov version 2 log, the format Lighthouse,
+// Cartographer and bncov load
+func WriteDrcov(w io.Writer, module Module, blocks []Block) error {
+	out := bufio.NewWriter(w)
+	hit := 0
+	for _, block := range blocks {
+		if block.Hits > 0 {
+			hit++
+		}
+	}
+	fmt.Fprintf(out, "DRCOV VERSION: 2\nDRCOV FLAVOR: matcha\n")
+	fmt.Fprintf(out, "Module Table: version 2, count 1\nColumns: id, base, end, entry, checksum, timestamp, path\n")
+	fmt.Fprintf(out, " 0, 0x%016x, 0x%016x, 0x%016x, 0x%08x, 0x%08x, %s\n", module.Base, module.Base+module.Size, 0, 0, 0, module.Path)
+	fmt.Fprintf(out, "BB Table: %d bbs\n", hit)
+	for _, block := range blocks {
+		if block.Hits == 0 {
+			continue
+		}
+		// struct { u32 start; u16 size; u16 mod_id }
+		entry := make([]byte, 8)
+		binary.LittleEndian.PutUint32(entry, uint32(block.Offset))
+		binary.LittleEndian.PutUint16(entry[4:], uint16(min(block.Size, maxDrcovBlockSize)))
+		out.Write(entry)
+	}
+	return out.Flush()
+}
+
+// WriteLcov writes line hit counts, file to line to count, as an lcov tracefile
+func WriteLcov(w io.Writer, testName string, lines map[string]map[int]uint64) error {
+	out := bufio.NewWriter(w)
+	files := make([]string, 0, len(lines))
+	for file := range lines {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		numbers := make([]int, 0, len(lines[file]))
+		for number := range lines[file] {
+			// lcov lines count from 1, DWARF's line 0 is code without a source line
+			if number > 0 {
+				numbers = append(numbers, number)
+			}
+		}
+		if len(numbers) == 0 {
+			continue
+		}
+		sort.Ints(numbers)
+		fmt.Fprintf(out, "TN:%s\nSF:%s\n", testName, file)
+		hit := 0
+		for _, number := range numbers {
+			count := lines[file][number]
+			if count > 0 {
+				hit++
+			}
+			fmt.Fprintf(out, "DA:%d,%d\n", number, count)
+		}
+		fmt.Fprintf(out, "LF:%d\nLH:%d\nend_of_record\n", len(numbers), hit)
+	}
+	return out.Flush()
+}
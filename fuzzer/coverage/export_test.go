@@ -0,0 +1,144 @@
+package coverage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWriteLcov(t *testing.T) {
+	lines := map[string]map[int]uint64{
+		"/src/parse.c": {12: 3, 3: 0, 7: 1},
+		"/src/main.c":  {1: 1},
+		// line 0 is code without a source line, lcov has no such line
+		"/src/gen.c":  {0: 5},
+		"/src/util.c": {0: 2, 4: 0},
+	}
+	want := `TN:test
+SF:/src/main.c
+DA:1,1
+LF:1
+LH:1
+end_of_record
+TN:test
+SF:/src/parse.c
+DA:3,0
+DA:7,1
+DA:12,3
+LF:3
+LH:2
+end_of_record
+TN:test
+SF:/src/util.c
+DA:4,0
+LF:1
+LH:0
+end_of_record
+`
+	var out bytes.Buffer
+	if err := WriteLcov(&out, "test", lines); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("WriteLcov =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+// readDrcov parses a drcov log back into its module line and the blocks of its BB table
+func readDrcov(t *testing.T, data []byte) (string, []Block) {
+	t.Helper()
+	in := bufio.NewReader(bytes.NewReader(data))
+	module := ""
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			t.Fatalf("no BB table: %v", err)
+		}
+		if strings.HasPrefix(line, " 0, ") {
+			module = strings.TrimSpace(line)
+		}
+		var count int
+		if _, err := fmt.Sscanf(line, "BB Table: %d bbs\n", &count); err == nil {
+			blocks := make([]Block, 0, count)
+			entry := make([]byte, 8)
+			for range count {
+				if _, err := io.ReadFull(in, entry); err != nil {
+					t.Fatalf("truncated BB table: %v", err)
+				}
+				if id := binary.LittleEndian.Uint16(entry[6:]); id != 0 {
+					t.Errorf("module id = %d, want 0", id)
+				}
+				blocks = append(blocks, Block{
+					Offset: uint64(binary.LittleEndian.Uint32(entry)),
+					Size:   uint64(binary.LittleEndian.Uint16(entry[4:])),
+				})
+			}
+			if rest, _ := io.ReadAll(in); len(rest) != 0 {
+				t.Errorf("%d bytes after the BB table", len(rest))
+			}
+			return module, blocks
+		}
+	}
+}
+
+func TestWriteDrcov(t *testing.T) {
+	module := Module{Path: "/bin/target", Base: 0x400000, Size: 0x3000}
+	tests := []struct {
+		name   string
+		blocks []Block
+		want   []Block
+	}{
+		{"no blocks", nil, []Block{}},
+		{"only hit blocks", []Block{{Offset: 0x1000, Size: 0x10, Hits: 1}, {Offset: 0x1010, Size: 4, Hits: 0}, {Offset: 0x1200, Size: 8, Hits: 9}},
+			[]Block{{Offset: 0x1000, Size: 0x10}, {Offset: 0x1200, Size: 8}}},
+		{"size clamped", []Block{{Offset: 0x2000, Size: 0x20000, Hits: 1}}, []Block{{Offset: 0x2000, Size: maxDrcovBlockSize}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := WriteDrcov(&out, module, test.blocks); err != nil {
+				t.Fatal(err)
+			}
+			line, blocks := readDrcov(t, out.Bytes())
+			wantLine := "0, 0x0000000000400000, 0x0000000000403000, 0x0000000000000000, 0x00000000, 0x00000000, /bin/target"
+			if line != wantLine {
+				t.Errorf("module = %q, want %q", line, wantLine)
+			}
+			if !slices.Equal(blocks, test.want) {
+				t.Errorf("blocks = %+v, want %+v", blocks, test.want)
+			}
+		})
+	}
+}
+
+func TestSizeBlocks(t *testing.T) {
+	// functions are [0x100, 0x140) and [0x200, 0x210)
+	limit := func(offset uint64) uint64 {
+		switch {
+		case offset >= 0x100 && offset < 0x140:
+			return 0x140
+		case offset >= 0x200 && offset < 0x210:
+			return 0x210
+		}
+		return 0
+	}
+	hits := map[uint64]uint64{0x100: 2, 0x200: 1}
+	got := SizeBlocks([]uint64{0x200, 0x120, 0x100, 0x120, 0x300, 0x208}, hits, limit)
+	want := []Block{
+		{Offset: 0x100, Size: 0x20, Hits: 2},
+		// the last block of a function ends with it, not at the next block
+		{Offset: 0x120, Size: 0x20},
+		{Offset: 0x200, Size: 8, Hits: 1},
+		{Offset: 0x208, Size: 8},
+		// outside any function the last block has size 1
+		{Offset: 0x300, Size: 1},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("SizeBlocks = %+v, want %+v", got, want)
+	}
+}
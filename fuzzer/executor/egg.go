@@ -0,0 +1,29 @@
+package executor
+
+import (
+	"bytes"
+)
+
+func findAllOccurrences(data []byte, search []byte, regionOffset uint64) []uint64 {
+	results := make([]uint64, 0)
+	searchData := data
+	term := search
+	for x, d := bytes.Index(searchData, term), 0; x > -1; x, d = bytes.Index(searchData, term), d+x+1 {
+		results = append(results, uint64((x+d))+uint64(regionOffset))
+		searchData = searchData[x+1:]
+	}
+	return results
+}
+
+// FindEgg returns every address of the snapshot memory holding egg, the input used to
+// reach the snapshot, so cases can be written over it
+func (e *Executor) FindEgg(egg []byte) ([]uint64, error) {
+	locations := make([]uint64, 0)
+	for _, mem := range e.SnapshotData.Memory {
+		locations = append(locations, findAllOccurrences(mem.RawData, egg, mem.Start)...)
+	}
+	if len(locations) == 0 {
+		return nil, ErrEggNotFound
+	}
+	return locations, nil
+}
@@ -0,0 +1,520 @@
+// Package executor runs a target under ptrace, spawning it fresh for every case or running
+// it from a snapshot, and reports how each exec ended. The thread calling into an Executor
+// has to stay locked with runtime.LockOSThread, ptrace only answers the tracer thread
+package executor
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"matcha/fuzzer/coverage"
+	"matcha/fuzzer/ptrace"
+	"matcha/internal/snapshot"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const ADDR_NO_RANDOMIZE = 0x0040000
+
+// ErrEggNotFound is returned by FindEgg when the egg is nowhere in the snapshot
+var ErrEggNotFound = errors.New("failed to find egg")
+
+// WrongBreakPointError is a stop at another address than the one the tracee was run to
+type WrongBreakPointError struct {
+	Wanted uint64
+	Got    uint64
+}
+
+func (e *WrongBreakPointError) Error() string {
+	return fmt.Sprintf("wrong breakpoint wanted 0x%x got 0x%x", e.Wanted, e.Got)
+}
+
+type Outcome int
+
+const (
+	// Exited means the tracee is gone, it exited or was ended by the input delivery
+	Exited Outcome = iota
+	// RestorePoint means the tracee sits at the restore address ready for RestoreSnapshot
+	RestorePoint
+	// Crashed means the tracee is stopped with a fatal signal
+	Crashed
+	// Hung means the tracee ran past Timeout and is stopped wherever it was
+	Hung
+)
+
+// Result is how an exec ended, Signal, PC and Fault are set for crashes. Stderr is the end
+// of what the tracee wrote to stderr during the exec when it crashed or exited and
+// StderrLimit is set
+type Result struct {
+	Outcome Outcome
+	Signal  syscall.Signal
+	PC      uint64
+	Fault   *Fault
+	Stderr  []byte
+}
+
+type Executor struct {
+	Pid                  int
+	Path                 string
+	BaseAddress          uint64
+	Coverage             *coverage.Map
+	SnapshotAddress      uint64
+	SnapshotAddressBytes []byte
+	RestoreAddress       uint64
+	RestoreAddressBytes  []byte
+	RestoreOnReturn      bool
+	SnapshotData         snapshot.Snapshot
+	CurrentFuzzCase      []byte
+	DevNull              *os.File
+	NoASLR               bool
+	SyscallHandlers      []SyscallHandler
+	// Stdout and Stderr of spawned tracees, DevNull when nil
+	Stdout *os.File
+	Stderr *os.File
+	// ExitStatus is how the last tracee that went away ended
+	ExitStatus syscall.WaitStatus
+	// StderrLimit is how many of the last bytes a tracee wrote to stderr are kept for the
+	// results of crashes and exits, 0 sends stderr to DevNull or Stderr
+	StderrLimit int
+	// Env is added to the environment of spawned tracees
+	Env    []string
+	stderr *stderrCapture
+	// Interrupted reports that a SIGSTOP of the tracee was sent to end the exec
+	Interrupted func() bool
+	// OnNewBlock is called for every block hit for the first time
+	OnNewBlock func(address uint64)
+	// OnBlock keeps breakpoints armed after their first hit and is called for every block
+	// executed, in order. The first instruction of a block is single stepped with its
+	// original bytes before the breakpoint goes back
+	OnBlock func(address uint64)
+	// traced are the original bytes of blocks hit while tracing, rearmAddress is the block
+	// to step over before the tracee runs on
+	traced       map[uint64][]byte
+	rearmAddress uint64
+	// Timeout bounds every CoverageLoop, 0 lets a case run forever
+	Timeout  time.Duration
+	timedOut atomic.Bool
+}
+
+func New(path string, baseAddress uint64, snapshotAddress uint64, restoreAddress uint64) (*Executor, error) {
+	if baseAddress == 0 {
+		return nil, errors.New("no base address")
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Executor{
+		Path:            path,
+		BaseAddress:     baseAddress,
+		Coverage:        coverage.NewMap(nil),
+		SnapshotAddress: snapshotAddress,
+		RestoreAddress:  restoreAddress,
+		DevNull:         devNull,
+	}, nil
+}
+
+// Spawn starts the target with args stopped at its first instruction
+func (e *Executor) Spawn(args []string) error {
+	path := e.Path
+	cmd := exec.Command(path)
+	cmd.Args = []string{path}
+	cmd.Args = append(cmd.Args, args...)
+	cmd.Stdout = e.DevNull
+	cmd.Stderr = e.DevNull
+	if e.Stdout != nil {
+		cmd.Stdout = e.Stdout
+	}
+	if e.Stderr != nil {
+		cmd.Stderr = e.Stderr
+	}
+	if e.StderrLimit > 0 {
+		if e.stderr == nil {
+			var err error
+			if e.stderr, err = newStderrCapture(e.StderrLimit); err != nil {
+				return err
+			}
+		}
+		cmd.Stderr = e.stderr.write
+	}
+	if len(e.Env) > 0 {
+		cmd.Env = append(os.Environ(), e.Env...)
+	}
+	// a process group of its own keeps a Ctrl-C in the terminal away from the tracee
+	cmd.SysProcAttr = &syscall.SysProcAttr{Ptrace: true, Setpgid: true}
+	if e.NoASLR {
+		// personality is inherited by the child, this thread is locked so only it is affected
+		persona, _, _ := syscall.RawSyscall(syscall.SYS_PERSONALITY, 0xffffffff, 0, 0)
+		syscall.RawSyscall(syscall.SYS_PERSONALITY, persona|ADDR_NO_RANDOMIZE, 0, 0)
+		defer syscall.RawSyscall(syscall.SYS_PERSONALITY, persona, 0, 0)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// returns once the child stops at exec, the error only reports that stop
+	cmd.Wait()
+	e.Pid = cmd.Process.Pid
+	for _, handler := range e.SyscallHandlers {
+		if err := handler.Attach(e.Pid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Instrument sets the coverage breakpoints in the current tracee, and those of the blocks
+// already traced when tracing
+func (e *Executor) Instrument() error {
+	if err := e.Coverage.Instrument(e.Pid); err != nil {
+		return err
+	}
+	for address := range e.traced {
+		if _, ok := e.Coverage.BreakPoints[address]; ok {
+			continue
+		}
+		if _, err := ptrace.SetBP(e.Pid, address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CoverageLoop runs the tracee until it exits, crashes, hangs or reaches the restore
+// address, removing every coverage breakpoint it runs into
+func (e *Executor) CoverageLoop() (Result, error) {
+	if e.Timeout > 0 {
+		// the flag is set before the stop is sent so the SIGSTOP is never mistaken for a
+		// stray one, a stop that lands after the exec is over is ignored by the next one
+		pid := e.Pid
+		timer := time.AfterFunc(e.Timeout, func() {
+			e.timedOut.Store(true)
+			syscall.Kill(pid, syscall.SIGSTOP)
+		})
+		defer func() {
+			timer.Stop()
+			e.timedOut.Store(false)
+		}()
+	}
+	e.DiscardStderr()
+	for {
+		exited, signal, err := e.ContinueExec()
+		if err != nil {
+			return Result{}, err
+		}
+		// child exited spawn new
+		if exited {
+			return Result{Outcome: Exited, Stderr: e.CapturedStderr()}, nil
+		}
+		switch signal {
+		case syscall.SIGSEGV, syscall.SIGBUS, syscall.SIGABRT, syscall.SIGFPE, syscall.SIGILL:
+			fault, err := e.ReadFault()
+			if err != nil {
+				return Result{}, err
+			}
+			return Result{Outcome: Crashed, Signal: signal, PC: fault.Regs.PC(), Fault: fault, Stderr: e.CapturedStderr()}, nil
+		case syscall.SIGTRAP:
+			restore, err := e.UpdateCoverage()
+			if err != nil {
+				return Result{}, err
+			}
+			if restore {
+				return Result{Outcome: RestorePoint}, nil
+			}
+		case syscall.SIGSTOP:
+			if e.timedOut.Load() {
+				return Result{Outcome: Hung}, nil
+			}
+		}
+	}
+}
+
+// ContinueExec resumes the tracee until it stops with a signal or exits, syscall stops are
+// handed to the syscall handlers and never reach the caller
+func (e *Executor) ContinueExec() (bool, syscall.Signal, error) {
+	var ws syscall.WaitStatus
+	var err error
+	stopped := false
+	if e.rearmAddress != 0 {
+		if ws, err = e.stepOverBlock(); err != nil {
+			return false, 0, err
+		}
+		// anything but the trap ending the step is handled like a stop after a continue
+		stopped = !ws.Stopped() || ws.StopSignal() != syscall.SIGTRAP
+	}
+	for !stopped {
+		if len(e.SyscallHandlers) > 0 {
+			err = ptrace.Syscall(e.Pid)
+		} else {
+			err = ptrace.Cont(e.Pid)
+		}
+		if err != nil {
+			return false, 0, err
+		}
+		ws, err = ptrace.Wait(e.Pid)
+		if err != nil {
+			return false, 0, err
+		}
+		if len(e.SyscallHandlers) == 0 || !ws.Stopped() || ws.StopSignal() != syscall.SIGTRAP|0x80 {
+			break
+		}
+		for _, handler := range e.SyscallHandlers {
+			if err := handler.HandleSyscall(e); err != nil {
+				return false, 0, err
+			}
+		}
+	}
+	// if process exited handle that
+	if ws.Exited() || ws.Signaled() {
+		// the tracee is reaped, forgetting its pid keeps anything from signalling a reused one
+		e.ExitStatus = ws
+		e.Pid = 0
+		e.rearmAddress = 0
+		return true, -1, nil
+	}
+	// the input delivery stopped the tracee because the exec is over
+	if ws.StopSignal() == syscall.SIGSTOP && e.Interrupted != nil && e.Interrupted() {
+		e.KillTracee()
+		return true, -1, nil
+	}
+	// if we got a signal could mean a crash handle that
+	switch ws.StopSignal() {
+	case syscall.SIGSEGV:
+		return false, syscall.SIGSEGV, nil
+	case syscall.SIGBUS:
+		return false, syscall.SIGBUS, nil
+	case syscall.SIGABRT:
+		return false, syscall.SIGABRT, nil
+	case syscall.SIGFPE:
+		return false, syscall.SIGFPE, nil
+	case syscall.SIGILL:
+		return false, syscall.SIGILL, nil
+	case syscall.SIGTRAP:
+		return false, syscall.SIGTRAP, nil
+	case syscall.SIGSTOP:
+		return false, syscall.SIGSTOP, nil
+	default:
+		return false, syscall.Signal(-1), nil
+	}
+}
+
+// KillTracee kills and reaps the current tracee, the pid is forgotten so a second call does
+// not hit a recycled one
+func (e *Executor) KillTracee() {
+	ptrace.Kill(e.Pid)
+	e.Pid = 0
+	e.rearmAddress = 0
+}
+
+// UpdateCoverage handles a SIGTRAP, true when it is the restore point
+func (e *Executor) UpdateCoverage() (bool, error) {
+	r, err := ptrace.GetRegs(e.Pid)
+	if err != nil {
+		return false, err
+	}
+	pc := r.PC() - 1
+	if e.RestoreAddress == pc {
+		if e.RestoreOnReturn {
+			return false, e.ArmReturnBreakPoint()
+		}
+		return true, nil
+	}
+	if e.OnBlock != nil {
+		return false, e.traceBlock(pc)
+	}
+	if err := e.Coverage.HitBreakPoint(e.Pid, pc); err != nil {
+		return false, err
+	}
+	if e.OnNewBlock != nil {
+		e.OnNewBlock(pc)
+	}
+	return false, nil
+}
+
+// traceBlock reports the block at pc to OnBlock and takes its breakpoint out until the next
+// ContinueExec stepped over it
+func (e *Executor) traceBlock(pc uint64) error {
+	if e.traced == nil {
+		e.traced = make(map[uint64][]byte)
+	}
+	if original, ok := e.Coverage.BreakPoints[pc]; ok {
+		if err := e.Coverage.HitBreakPoint(e.Pid, pc); err != nil {
+			return err
+		}
+		e.traced[pc] = original
+		if e.OnNewBlock != nil {
+			e.OnNewBlock(pc)
+		}
+	} else if original, ok := e.traced[pc]; ok {
+		if err := ptrace.DelBP(e.Pid, pc, original); err != nil {
+			return err
+		}
+		if err := ptrace.SubRip(e.Pid); err != nil {
+			return err
+		}
+	} else {
+		return &coverage.UnknownBreakPointError{PC: pc}
+	}
+	e.OnBlock(pc)
+	e.rearmAddress = pc
+	return nil
+}
+
+// stepOverBlock runs the first instruction of the block traced last and arms its breakpoint
+// again. A syscall made by that one instruction is not seen by the syscall handlers
+func (e *Executor) stepOverBlock() (syscall.WaitStatus, error) {
+	address := e.rearmAddress
+	e.rearmAddress = 0
+	if err := ptrace.SingleStep(e.Pid); err != nil {
+		return 0, err
+	}
+	ws, err := ptrace.Wait(e.Pid)
+	if err != nil || !ws.Stopped() {
+		return ws, err
+	}
+	_, err = ptrace.SetBP(e.Pid, address)
+	return ws, err
+}
+
+// The restore point is the return of a function and we are stopped at its entry, so the
+// return address is on top of the stack. The snapshot puts the same stack back every
+// iteration so the breakpoint only has to move once
+func (e *Executor) ArmReturnBreakPoint() error {
+	r, err := ptrace.GetRegs(e.Pid)
+	if err != nil {
+		return err
+	}
+	returnAddress := make([]byte, 8)
+	if err := e.ReadBufferFromProcess(r.Rsp, returnAddress); err != nil {
+		return err
+	}
+	if err := ptrace.DelBP(e.Pid, e.RestoreAddress, e.RestoreAddressBytes); err != nil {
+		return err
+	}
+	if err := ptrace.SubRip(e.Pid); err != nil {
+		return err
+	}
+	e.RestoreAddress = binary.LittleEndian.Uint64(returnAddress)
+	if e.RestoreAddressBytes, err = ptrace.SetBP(e.Pid, e.RestoreAddress); err != nil {
+		return err
+	}
+	e.RestoreOnReturn = false
+	fmt.Printf("Restore Point Is Return Address 0x%x\n", e.RestoreAddress)
+	return nil
+}
+
+func (e *Executor) RestoreSnapshot() error {
+	if err := ptrace.SetRegs(e.Pid, e.SnapshotData.Registers); err != nil {
+		return err
+	}
+	for i := range e.SnapshotData.Memory {
+		if err := snapshot.WriteRegionToProcess(e.Pid, e.SnapshotData.Memory[i]); err != nil {
+			return err
+		}
+	}
+	if err := snapshot.RestoreFiles(e.Pid, e.SnapshotData.Files); err != nil {
+		return err
+	}
+	for _, handler := range e.SyscallHandlers {
+		handler.Restore()
+	}
+	return nil
+}
+
+// runTo runs the tracee to a one off breakpoint at address and removes it
+func (e *Executor) runTo(address uint64) error {
+	original, err := ptrace.SetBP(e.Pid, address)
+	if err != nil {
+		return err
+	}
+	exited, _, err := e.ContinueExec()
+	if err != nil {
+		return err
+	}
+	if exited {
+		return fmt.Errorf("running to 0x%x: %w", address, ptrace.ErrExited)
+	}
+	r, err := ptrace.GetRegs(e.Pid)
+	if err != nil {
+		return err
+	}
+	if pc := r.PC() - 1; pc != address {
+		return &WrongBreakPointError{Wanted: address, Got: pc}
+	}
+	if err := ptrace.DelBP(e.Pid, address, original); err != nil {
+		return err
+	}
+	return ptrace.SubRip(e.Pid)
+}
+
+func (e *Executor) TakeSnapshot() error {
+	fmt.Println("Taking Child Snapshot")
+	// Run Until We Hit The Snapshot BreakPoint
+	if err := e.runTo(e.SnapshotAddress); err != nil {
+		return err
+	}
+	var err error
+	if e.SnapshotData, err = snapshot.NewSnapshot(e.Pid); err != nil {
+		return err
+	}
+	for _, handler := range e.SyscallHandlers {
+		handler.Save()
+	}
+	// Armed after the snapshot so it can share the snapshot address, like ret:crash with crash
+	if e.RestoreAddressBytes, err = ptrace.SetBP(e.Pid, e.RestoreAddress); err != nil {
+		return err
+	}
+	fmt.Printf("Snapshot Complete %d regions %d fds\n", len(e.SnapshotData.Memory), len(e.SnapshotData.Files))
+	// Set BreakPoints for the whole process now to get coverage
+	// You Instrument AFTER the snapshot and reinstrument on the restore
+	fmt.Println("Instrumenting Child")
+	return e.Instrument()
+}
+
+// ResumeSnapshot brings a freshly spawned tracee into the state of a snapshot loaded from disk
+// instead of running it to the snapshot address. The tracee is run to the entry point so the
+// loader has mapped the binary and libraries, then the missing regions are mapped and the
+// snapshot is written over it. Requires the snapshot to have been taken with ASLR disabled
+func (e *Executor) ResumeSnapshot(snap snapshot.Snapshot) error {
+	entry, err := e.EntryPoint()
+	if err != nil {
+		return err
+	}
+	if err := e.runTo(entry); err != nil {
+		return err
+	}
+	if err := snapshot.MapRegions(e.Pid, snap.Memory); err != nil {
+		return err
+	}
+	e.SnapshotData = snap
+	if err := e.RestoreSnapshot(); err != nil {
+		return err
+	}
+	if e.RestoreAddressBytes, err = ptrace.SetBP(e.Pid, e.RestoreAddress); err != nil {
+		return err
+	}
+	return e.Instrument()
+}
+
+func (e *Executor) EntryPoint() (uint64, error) {
+	f, err := elf.Open(e.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if f.Type == elf.ET_DYN {
+		return e.BaseAddress + f.Entry, nil
+	}
+	return f.Entry, nil
+}
+
+func (e *Executor) ReadBufferFromProcess(address uint64, buffer []byte) error {
+	return ptrace.ReadMemory(e.Pid, address, buffer)
+}
+
+func (e *Executor) WriteBufferToProcess(address uint64, buffer []byte) error {
+	return ptrace.WriteMemory(e.Pid, address, buffer)
+}
@@ -0,0 +1,114 @@
+package executor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"matcha/fuzzer/ptrace"
+	"matcha/internal/snapshot"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// bytes read at pc, the longest x86 instruction is 15
+const faultInstructionSize = 16
+
+// bytes of stack read from the stack pointer up to look for return addresses
+const faultStackSize = 8 * 1024
+
+// Fault is the state of a tracee stopped by a crash signal, what crashes are rated from.
+// It is read before the tracee is killed
+type Fault struct {
+	Regs syscall.PtraceRegs
+	// Address and Code are si_addr and si_code of the signal. Address is what was accessed
+	// for SIGSEGV and SIGBUS, 0 for a general protection fault on a non canonical address
+	Address uint64
+	Code    int32
+	// Instruction are the bytes at pc, empty when pc isn't mapped
+	Instruction []byte
+	// Stack are the words from the stack pointer up to the end of its mapping or
+	// faultStackSize
+	Stack    []uint64
+	Mappings []Mapping
+}
+
+// Mapping is a line of /proc/<pid>/maps
+type Mapping struct {
+	Start  uint64
+	End    uint64
+	Perms  string
+	Offset uint64
+	Path   string
+}
+
+func (m *Mapping) Executable() bool {
+	return strings.Contains(m.Perms, "x")
+}
+
+// MappingAt is the mapping containing address, nil when it isn't mapped
+func (f *Fault) MappingAt(address uint64) *Mapping {
+	for i := range f.Mappings {
+		if address >= f.Mappings[i].Start && address < f.Mappings[i].End {
+			return &f.Mappings[i]
+		}
+	}
+	return nil
+}
+
+// ReadFault gathers the Fault of the stopped tracee. Only the registers and siginfo are
+// required, memory that can't be read leaves Instruction or Stack empty
+func (e *Executor) ReadFault() (*Fault, error) {
+	fault := &Fault{}
+	var err error
+	if fault.Regs, err = ptrace.GetRegs(e.Pid); err != nil {
+		return nil, err
+	}
+	info, err := ptrace.GetSigInfo(e.Pid)
+	if err != nil {
+		return nil, err
+	}
+	fault.Address, fault.Code = info.Address, info.Code
+	if fault.Mappings, err = ReadMappings(e.Pid); err != nil {
+		return nil, err
+	}
+	if m := fault.MappingAt(fault.Regs.Rip); m != nil {
+		fault.Instruction, _ = snapshot.ReadRegionFromProcess(e.Pid, fault.Regs.Rip, min(m.End, fault.Regs.Rip+faultInstructionSize))
+	}
+	if m := fault.MappingAt(fault.Regs.Rsp); m != nil {
+		stack, _ := snapshot.ReadRegionFromProcess(e.Pid, fault.Regs.Rsp, min(m.End, fault.Regs.Rsp+faultStackSize))
+		for i := 0; i+8 <= len(stack); i += 8 {
+			fault.Stack = append(fault.Stack, binary.LittleEndian.Uint64(stack[i:]))
+		}
+	}
+	return fault, nil
+}
+
+// ReadMappings parses /proc/<pid>/maps
+func ReadMappings(pid int) ([]Mapping, error) {
+	raw, err := os.ReadFile(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, err
+	}
+	mappings := make([]Mapping, 0)
+	for _, line := range strings.Split(string(raw), "\n") {
+		// start-end perms offset dev inode path, the path is missing for anonymous memory
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		var m Mapping
+		if _, err := fmt.Sscanf(fields[0], "%x-%x", &m.Start, &m.End); err != nil {
+			return nil, fmt.Errorf("bad maps line %q", line)
+		}
+		m.Perms = fields[1]
+		if m.Offset, err = strconv.ParseUint(fields[2], 16, 64); err != nil {
+			return nil, fmt.Errorf("bad maps line %q", line)
+		}
+		if len(fields) > 5 {
+			m.Path = strings.Join(fields[5:], " ")
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, nil
+}
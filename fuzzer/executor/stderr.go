@@ -0,0 +1,73 @@
+package executor
+
+import (
+	"os"
+	"syscall"
+)
+
+// F_SETPIPE_SZ, a bigger pipe lets a chatty case finish without a reader
+const fSetPipeSize = 1031
+
+const stderrPipeSize = 1 << 20
+
+// stderrCapture keeps the last bytes the tracees wrote to stderr. One non-blocking pipe is
+// shared by every tracee and read between execs, a case writing more than the pipe holds
+// blocks until it times out
+type stderrCapture struct {
+	read  *os.File
+	write *os.File
+	// fd is read's descriptor, calling Fd again would put it back into blocking mode
+	fd    int
+	limit int
+	data  []byte
+}
+
+func newStderrCapture(limit int) (*stderrCapture, error) {
+	read, write, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	syscall.Syscall(syscall.SYS_FCNTL, write.Fd(), fSetPipeSize, stderrPipeSize)
+	// the fd is read raw so the runtime poller never parks us on it
+	fd := int(read.Fd())
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		read.Close()
+		write.Close()
+		return nil, err
+	}
+	return &stderrCapture{read: read, write: write, fd: fd, limit: limit}, nil
+}
+
+// drain reads whatever is in the pipe keeping the last limit bytes
+func (c *stderrCapture) drain() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(c.fd, buf)
+		if n <= 0 || err != nil {
+			return
+		}
+		c.data = append(c.data, buf[:n]...)
+		if len(c.data) > c.limit {
+			c.data = append(c.data[:0], c.data[len(c.data)-c.limit:]...)
+		}
+	}
+}
+
+// DiscardStderr forgets what the tracee wrote to stderr so far
+func (e *Executor) DiscardStderr() {
+	if e.stderr == nil {
+		return
+	}
+	e.stderr.drain()
+	e.stderr.data = e.stderr.data[:0]
+}
+
+// CapturedStderr is a copy of the end of what the tracee wrote to stderr since the last
+// DiscardStderr, nil when StderrLimit is 0
+func (e *Executor) CapturedStderr() []byte {
+	if e.stderr == nil {
+		return nil
+	}
+	e.stderr.drain()
+	return append([]byte(nil), e.stderr.data...)
+}
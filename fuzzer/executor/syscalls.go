@@ -0,0 +1,35 @@
+package executor
+
+import (
+	"matcha/fuzzer/ptrace"
+	"syscall"
+)
+
+// SyscallHandler emulates part of the kernel for the tracee, when any are installed the
+// tracee runs under PTRACE_SYSCALL and every syscall stop is passed to each handler
+type SyscallHandler interface {
+	// Attach is called on every freshly spawned tracee before it runs
+	Attach(pid int) error
+	// Save and Restore keep the emulated state in step with snapshots
+	Save()
+	Restore()
+	// HandleSyscall is called at every syscall stop, stops alternate between entry and exit
+	HandleSyscall(e *Executor) error
+}
+
+// SkipSyscall stops the kernel from running the syscall the tracee is entering, rax has to be
+// set to the result at the exit stop
+func SkipSyscall(pid int, regs *syscall.PtraceRegs) error {
+	regs.Orig_rax = ^uint64(0)
+	return ptrace.SetRegs(pid, *regs)
+}
+
+// ReplaceSyscall swaps the syscall the tracee is entering for another one
+func ReplaceSyscall(pid int, regs *syscall.PtraceRegs, number uint64, args ...uint64) error {
+	regs.Orig_rax = number
+	argRegs := []*uint64{&regs.Rdi, &regs.Rsi, &regs.Rdx, &regs.R10, &regs.R8, &regs.R9}
+	for i, arg := range args {
+		*argRegs[i] = arg
+	}
+	return ptrace.SetRegs(pid, *regs)
+}
@@ -0,0 +1,120 @@
+// Package mutator holds the byte level mutations applied to corpus entries and the message
+// level ones for sequence entries, behind the Mutator interface custom mutators implement
+package mutator
+
+import (
+	"math/rand"
+)
+
+// Mutator turns a copy of a corpus entry into the next case to run
+type Mutator interface {
+	// Init is called once before the first case
+	Init(seed int64) error
+	// Mutate may change data in place, the case it returns must fit in maxSize bytes
+	Mutate(data []byte, maxSize int) ([]byte, error)
+}
+
+// Splicer is implemented by mutators that can combine a case with another corpus entry,
+// it is called now and then before Mutate
+type Splicer interface {
+	Splice(data []byte, other []byte, maxSize int) ([]byte, error)
+}
+
+// PostProcessor is implemented by mutators that fix up every case right before it runs,
+// e.g. to recompute lengths and checksums the target checks
+type PostProcessor interface {
+	PostProcess(data []byte) ([]byte, error)
+}
+
+// Havoc is the built-in byte mutator, with Resize cases also grow and shrink up to the max
+// size. It draws from math/rand which is seeded by the campaign
+type Havoc struct {
+	Resize bool
+}
+
+func (h *Havoc) Init(seed int64) error {
+	return nil
+}
+
+func (h *Havoc) Mutate(data []byte, maxSize int) ([]byte, error) {
+	Mutate(data)
+	if h.Resize {
+		data = MutateSize(data, maxSize)
+	}
+	return data, nil
+}
+
+// Splice swaps the end of data for the end of other at a random point, without Resize the
+// case keeps its size
+func (h *Havoc) Splice(data []byte, other []byte, maxSize int) ([]byte, error) {
+	if len(data) < 2 || len(other) < 2 {
+		return data, nil
+	}
+	split := rand.Intn(len(data)-1) + 1
+	if !h.Resize {
+		if split < len(other) {
+			copy(data[split:], other[split:])
+		}
+		return data, nil
+	}
+	data = append(data[:split], other[rand.Intn(len(other)):]...)
+	return data[:min(len(data), maxSize)], nil
+}
+
+func MutateCustom(data []byte) {
+	for i := range data {
+		data[i] = 0x42
+	}
+}
+
+func Mutate(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	counter := 0
+	// Mutate 5% of the bytes
+	// ByteFlip Bit Flip And Random Insert
+	mutationsPerCycle := 5 * len(data) / 100
+	for {
+		randByte := rand.Intn((len(data))-0) + 0
+		randBitFlip := rand.Intn((7+1)-0) + 0
+		randByteFlip := rand.Intn((len(data))-0) + 0
+		randByteInsert := rand.Intn(255-0) + 0
+		randStrat := rand.Intn(5-0) + 0
+		switch randStrat {
+		case 0:
+			data[randByte] ^= (1 << randBitFlip)
+		case 1:
+			data[randByte] ^= byte(randByteFlip)
+		case 2:
+			data[randByte] = byte(randByteInsert)
+		case 3:
+			data[randByte] = 0x0
+		default:
+		}
+		counter++
+		if counter > mutationsPerCycle {
+			break
+		}
+	}
+}
+
+// MutateSize grows or shrinks data within maxSize, growing reuses the capacity of data when
+// there is enough. Truncate, append random bytes or duplicate a chunk
+func MutateSize(data []byte, maxSize int) []byte {
+	randStrat := rand.Intn(4)
+	switch {
+	case randStrat == 0 && len(data) > 1:
+		return data[:rand.Intn(len(data)-1)+1]
+	case randStrat == 1 && len(data) < maxSize:
+		grow := rand.Intn(min(maxSize-len(data), 64)) + 1
+		for i := 0; i < grow; i++ {
+			data = append(data, byte(rand.Intn(256)))
+		}
+	case randStrat == 2 && len(data) > 0 && len(data) < maxSize:
+		start := rand.Intn(len(data))
+		chunk := rand.Intn(min(len(data)-start, maxSize-len(data))) + 1
+		data = append(data, data[start:start+chunk]...)
+	}
+	return data
+}
@@ -0,0 +1,36 @@
+package mutator
+
+import (
+	"fmt"
+	"plugin"
+	"strings"
+)
+
+// Load builds a custom mutator from spec, a path ending in .so is opened as a Go plugin and
+// anything else is run as a subprocess command line
+func Load(spec string) (Mutator, error) {
+	if strings.HasSuffix(spec, ".so") {
+		return LoadPlugin(spec)
+	}
+	return StartSubprocess(strings.Fields(spec))
+}
+
+// LoadPlugin opens a plugin built with go build -buildmode=plugin against this module, it
+// has to export
+//
+//	func NewMutator() mutator.Mutator
+func LoadPlugin(path string) (Mutator, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	symbol, err := p.Lookup("NewMutator")
+	if err != nil {
+		return nil, err
+	}
+	newMutator, ok := symbol.(func() Mutator)
+	if !ok {
+		return nil, fmt.Errorf("%s: NewMutator is a %T not a func() mutator.Mutator", path, symbol)
+	}
+	return newMutator(), nil
+}
@@ -0,0 +1,108 @@
+package mutator
+
+import (
+	"encoding/binary"
+	"matcha/fuzzer/corpus"
+	"math/rand"
+)
+
+// Sequence corpus entries are an ordered list of messages for stateful servers, stored in
+// the framed format so the network emulation and loopback client deliver them unchanged.
+// Every message is prefixed by its length as a big endian u16
+const (
+	maxSequenceMessages = 64
+	maxMessageSize      = 0xffff
+	// MaxSequenceSize is the biggest sequence MutateSequence builds
+	MaxSequenceSize = maxSequenceMessages * (2 + maxMessageSize)
+)
+
+// Sequence is the built-in mutator for sequence entries, messages inserted come from Corpus
+type Sequence struct {
+	Corpus *corpus.Corpus
+}
+
+func (q *Sequence) Init(seed int64) error {
+	return nil
+}
+
+func (q *Sequence) Mutate(data []byte, maxSize int) ([]byte, error) {
+	return MutateSequence(data, q.Corpus), nil
+}
+
+// DecodeSequence splits a sequence into its messages, a message whose length runs past
+// the end of the data is cut short
+func DecodeSequence(data []byte) [][]byte {
+	messages := make([][]byte, 0)
+	for len(data) >= 2 {
+		length := int(binary.BigEndian.Uint16(data))
+		data = data[2:]
+		if length > len(data) {
+			length = len(data)
+		}
+		messages = append(messages, data[:length])
+		data = data[length:]
+	}
+	return messages
+}
+
+func EncodeSequence(messages [][]byte) []byte {
+	size := 0
+	for _, message := range messages {
+		size += 2 + len(message)
+	}
+	data := make([]byte, 0, size)
+	for _, message := range messages {
+		if len(message) > maxMessageSize {
+			message = message[:maxMessageSize]
+		}
+		data = binary.BigEndian.AppendUint16(data, uint16(len(message)))
+		data = append(data, message...)
+	}
+	return data
+}
+
+// MutateSequence returns a mutated copy of the sequence in data. Mutate a single message,
+// insert a message taken from another corpus entry, delete, duplicate or swap messages
+func MutateSequence(data []byte, c *corpus.Corpus) []byte {
+	messages := make([][]byte, 0)
+	for _, message := range DecodeSequence(data) {
+		messages = append(messages, append([]byte(nil), message...))
+	}
+	if len(messages) == 0 {
+		messages = append(messages, []byte{byte(rand.Intn(256))})
+	}
+	i := rand.Intn(len(messages))
+	randStrat := rand.Intn(5)
+	switch {
+	case randStrat == 1 && len(messages) < maxSequenceMessages:
+		messages = insertMessage(messages, rand.Intn(len(messages)+1), randomMessage(c))
+	case randStrat == 2 && len(messages) > 1:
+		messages = append(messages[:i], messages[i+1:]...)
+	case randStrat == 3 && len(messages) < maxSequenceMessages:
+		messages = insertMessage(messages, i+1, append([]byte(nil), messages[i]...))
+	case randStrat == 4 && len(messages) > 1:
+		j := rand.Intn(len(messages))
+		messages[i], messages[j] = messages[j], messages[i]
+	default:
+		Mutate(messages[i])
+		messages[i] = MutateSize(messages[i], maxMessageSize)
+	}
+	return EncodeSequence(messages)
+}
+
+func insertMessage(messages [][]byte, idx int, message []byte) [][]byte {
+	messages = append(messages, nil)
+	copy(messages[idx+1:], messages[idx:])
+	messages[idx] = message
+	return messages
+}
+
+// randomMessage picks a message of a random corpus entry so messages that already reach
+// some state get tried at other points of the conversation
+func randomMessage(c *corpus.Corpus) []byte {
+	messages := DecodeSequence(c.GetCaseByIdx(rand.Intn(len(c.CorpusBuffers))))
+	if len(messages) == 0 {
+		return []byte{byte(rand.Intn(256))}
+	}
+	return append([]byte(nil), messages[rand.Intn(len(messages))]...)
+}
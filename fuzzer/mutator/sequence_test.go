@@ -0,0 +1,67 @@
+package mutator
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecodeSequence(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want [][]byte
+	}{
+		{"empty", nil, [][]byte{}},
+		{"one message", []byte{0, 3, 'a', 'b', 'c'}, [][]byte{[]byte("abc")}},
+		{"two messages", []byte{0, 1, 'a', 0, 2, 'b', 'c'}, [][]byte{[]byte("a"), []byte("bc")}},
+		{"empty message", []byte{0, 0, 0, 1, 'a'}, [][]byte{{}, []byte("a")}},
+		{"truncated length prefix", []byte{0, 1, 'a', 0}, [][]byte{[]byte("a")}},
+		{"only half a length prefix", []byte{7}, [][]byte{}},
+		{"length past the end", []byte{0, 9, 'a', 'b'}, [][]byte{[]byte("ab")}},
+		{"big endian length", append([]byte{1, 0}, bytes.Repeat([]byte{'x'}, 0x100)...), [][]byte{bytes.Repeat([]byte{'x'}, 0x100)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := DecodeSequence(test.data)
+			if len(got) != len(test.want) {
+				t.Fatalf("DecodeSequence = %q, want %q", got, test.want)
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], test.want[i]) {
+					t.Errorf("message %d = %q, want %q", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeSequence(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages [][]byte
+	}{
+		{"no messages", nil},
+		{"empty message", [][]byte{{}}},
+		{"several messages", [][]byte{[]byte("HELO"), {}, []byte("QUIT\r\n")}},
+		{"biggest message", [][]byte{bytes.Repeat([]byte{'x'}, maxMessageSize)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := DecodeSequence(EncodeSequence(test.messages))
+			if len(got) != len(test.messages) {
+				t.Fatalf("round trip gave %d messages, want %d", len(got), len(test.messages))
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], test.messages[i]) {
+					t.Errorf("message %d = %q, want %q", i, got[i], test.messages[i])
+				}
+			}
+		})
+	}
+	// a message too big for its length prefix is cut short
+	long := bytes.Repeat([]byte{'y'}, maxMessageSize+10)
+	got := DecodeSequence(EncodeSequence([][]byte{long, []byte("next")}))
+	if len(got) != 2 || len(got[0]) != maxMessageSize || string(got[1]) != "next" {
+		t.Errorf("oversized message decoded to %d messages", len(got))
+	}
+}
@@ -0,0 +1,116 @@
+package mutator
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Subprocess runs a custom mutator as a child process talking over its stdin and stdout,
+// so mutators can be written in any language. A request is an op byte, the max size as a
+// big endian u32 and a payload prefixed by its big endian u32 length, a reply is a payload
+// prefixed the same way
+//
+//	'I' payload is the seed as a big endian u64, the reply lists the optional ops
+//	    supported, e.g. "SP"
+//	'M' payload is the case, the reply is the mutated case
+//	'S' payload is the case prefixed by its u32 length then the other entry, the reply is
+//	    the spliced case
+//	'P' payload is the case, the reply is the case to run
+//
+// The process gets EOF on stdin when matcha is done with it
+type Subprocess struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	in    *bufio.Writer
+	out   *bufio.Reader
+	ops   string
+}
+
+func StartSubprocess(args []string) (*Subprocess, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no mutator command")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	// like the tracee it stays out of the terminal's process group, a Ctrl-C has to let the
+	// current case finish
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &Subprocess{cmd: cmd, stdin: stdin, in: bufio.NewWriter(stdin), out: bufio.NewReader(stdout)}, nil
+}
+
+func (m *Subprocess) Init(seed int64) error {
+	payload := binary.BigEndian.AppendUint64(nil, uint64(seed))
+	ops, err := m.request('I', 0, payload)
+	if err != nil {
+		return err
+	}
+	m.ops = string(ops)
+	return nil
+}
+
+func (m *Subprocess) Mutate(data []byte, maxSize int) ([]byte, error) {
+	return m.request('M', maxSize, data)
+}
+
+// Splice leaves data alone when the process did not list 'S' at init
+func (m *Subprocess) Splice(data []byte, other []byte, maxSize int) ([]byte, error) {
+	if !strings.Contains(m.ops, "S") {
+		return data, nil
+	}
+	payload := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	payload = append(append(payload, data...), other...)
+	return m.request('S', maxSize, payload)
+}
+
+// PostProcess leaves data alone when the process did not list 'P' at init
+func (m *Subprocess) PostProcess(data []byte) ([]byte, error) {
+	if !strings.Contains(m.ops, "P") {
+		return data, nil
+	}
+	return m.request('P', len(data), data)
+}
+
+// Close ends the process and waits for it
+func (m *Subprocess) Close() error {
+	m.stdin.Close()
+	return m.cmd.Wait()
+}
+
+func (m *Subprocess) request(op byte, maxSize int, payload []byte) ([]byte, error) {
+	header := make([]byte, 9)
+	header[0] = op
+	binary.BigEndian.PutUint32(header[1:], uint32(maxSize))
+	binary.BigEndian.PutUint32(header[5:], uint32(len(payload)))
+	m.in.Write(header)
+	m.in.Write(payload)
+	if err := m.in.Flush(); err != nil {
+		return nil, fmt.Errorf("mutator %s: %w", m.cmd.Path, err)
+	}
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(m.out, length); err != nil {
+		return nil, fmt.Errorf("mutator %s op %c: %w", m.cmd.Path, op, err)
+	}
+	reply := make([]byte, binary.BigEndian.Uint32(length))
+	if _, err := io.ReadFull(m.out, reply); err != nil {
+		return nil, fmt.Errorf("mutator %s op %c: %w", m.cmd.Path, op, err)
+	}
+	return reply, nil
+}
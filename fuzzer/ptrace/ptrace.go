@@ -0,0 +1,192 @@
+// Package ptrace wraps the ptrace requests matcha makes on a tracee. Every failure is an
+// *Error naming the request, so callers can tell a tracee that went away (IsGone) from a
+// real problem and decide whether to retry, drop the case or give up
+package ptrace
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Error is a failed request on a tracee
+type Error struct {
+	Op      string
+	Pid     int
+	Address uint64
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Address != 0 {
+		return fmt.Sprintf("%s pid %d at 0x%x: %v", e.Op, e.Pid, e.Address, e.Err)
+	}
+	return fmt.Sprintf("%s pid %d: %v", e.Op, e.Pid, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrExited is returned when the tracee exited or was killed while a request needed it
+// stopped
+var ErrExited = errors.New("tracee exited")
+
+// IsGone reports whether err means the tracee no longer exists, the usual transient error
+// of a dying child. Its /proc entries vanish with it so ENOENT from one of our requests
+// counts as well
+func IsGone(err error) bool {
+	if errors.Is(err, syscall.ESRCH) || errors.Is(err, syscall.ECHILD) || errors.Is(err, ErrExited) {
+		return true
+	}
+	var request *Error
+	return errors.As(err, &request) && errors.Is(request.Err, syscall.ENOENT)
+}
+
+func wrap(op string, pid int, address uint64, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Op: op, Pid: pid, Address: address, Err: err}
+}
+
+// SetBP writes an int3 at address and returns the byte it replaced
+func SetBP(pid int, address uint64) ([]byte, error) {
+	original := make([]byte, 1)
+	if _, err := syscall.PtracePeekData(pid, uintptr(address), original); err != nil {
+		return nil, wrap("SetBP peek", pid, address, err)
+	}
+	if _, err := syscall.PtracePokeData(pid, uintptr(address), []byte{0xCC}); err != nil {
+		return nil, wrap("SetBP poke", pid, address, err)
+	}
+	return original, nil
+}
+
+// DelBP puts back the bytes SetBP replaced
+func DelBP(pid int, address uint64, originalBytes []byte) error {
+	_, err := syscall.PtracePokeData(pid, uintptr(address), originalBytes)
+	return wrap("DelBP", pid, address, err)
+}
+
+func SingleStep(pid int) error {
+	return wrap("SingleStep", pid, 0, syscall.PtraceSingleStep(pid))
+}
+
+// Syscall resumes the tracee until the next syscall entry or exit
+func Syscall(pid int) error {
+	return wrap("Syscall", pid, 0, syscall.PtraceSyscall(pid, 0))
+}
+
+func Cont(pid int) error {
+	return wrap("Cont", pid, 0, syscall.PtraceCont(pid, 0))
+}
+
+func SetOptions(pid int, options int) error {
+	return wrap("SetOptions", pid, 0, syscall.PtraceSetOptions(pid, options))
+}
+
+func GetRegs(pid int) (syscall.PtraceRegs, error) {
+	var regs syscall.PtraceRegs
+	err := syscall.PtraceGetRegs(pid, &regs)
+	return regs, wrap("GetRegs", pid, 0, err)
+}
+
+// PTRACE_GETSIGINFO, missing from the syscall package
+const getSigInfo = 0x4202
+
+// SigInfo is the part of the siginfo_t of a signal stop matcha looks at. Address is si_addr,
+// the faulting address for SIGSEGV, SIGBUS, SIGILL and SIGFPE
+type SigInfo struct {
+	Signo   int32
+	Code    int32
+	Address uint64
+}
+
+// GetSigInfo reads the siginfo of the signal the tracee is stopped with
+func GetSigInfo(pid int) (SigInfo, error) {
+	// siginfo_t is 128 bytes, si_signo, si_errno and si_code then si_addr at 16 on amd64
+	var raw [128]byte
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, getSigInfo, uintptr(pid), 0, uintptr(unsafe.Pointer(&raw[0])), 0, 0)
+	if errno != 0 {
+		return SigInfo{}, wrap("GetSigInfo", pid, 0, errno)
+	}
+	return SigInfo{
+		Signo:   int32(binary.LittleEndian.Uint32(raw[0:])),
+		Code:    int32(binary.LittleEndian.Uint32(raw[8:])),
+		Address: binary.LittleEndian.Uint64(raw[16:]),
+	}, nil
+}
+
+func SetRegs(pid int, regs syscall.PtraceRegs) error {
+	return wrap("SetRegs", pid, 0, syscall.PtraceSetRegs(pid, &regs))
+}
+
+// SubRip moves the pc back over the int3 that was just hit
+func SubRip(pid int) error {
+	regs, err := GetRegs(pid)
+	if err != nil {
+		return err
+	}
+	regs.Rip -= 1
+	return SetRegs(pid, regs)
+}
+
+// Wait waits for the next stop or the exit of the tracee
+func Wait(pid int) (syscall.WaitStatus, error) {
+	var ws syscall.WaitStatus
+	_, err := syscall.Wait4(pid, &ws, syscall.WALL, nil)
+	return ws, wrap("Wait4", pid, 0, err)
+}
+
+// Kill kills the tracee and reaps it
+func Kill(pid int) {
+	// 0 and -1 would signal our own process group or everything we can reach
+	if pid <= 0 {
+		return
+	}
+	syscall.Kill(pid, syscall.SIGKILL)
+	for {
+		ws, err := Wait(pid)
+		if err != nil || ws.Exited() || ws.Signaled() {
+			return
+		}
+	}
+}
+
+// ReadMemory fills buffer from the tracee memory at address
+func ReadMemory(pid int, address uint64, buffer []byte) error {
+	_, err := syscall.PtracePeekData(pid, uintptr(address), buffer)
+	return wrap("ReadMemory", pid, address, err)
+}
+
+// WriteMemory writes through /proc/<pid>/mem which also works on read only mappings
+func WriteMemory(pid int, address uint64, data []byte) error {
+	mem, err := os.OpenFile(fmt.Sprintf("/proc/%d/mem", pid), os.O_RDWR, 0644)
+	if err != nil {
+		return wrap("WriteMemory", pid, address, err)
+	}
+	defer mem.Close()
+	_, err = mem.WriteAt(data, int64(address))
+	return wrap("WriteMemory", pid, address, err)
+}
+
+// ReadString reads a nul terminated string of at most 4096 bytes
+func ReadString(pid int, address uint64) (string, error) {
+	out := make([]byte, 0, 64)
+	chunk := make([]byte, 8)
+	for len(out) < 4096 {
+		if err := ReadMemory(pid, address+uint64(len(out)), chunk); err != nil {
+			return "", err
+		}
+		for i, b := range chunk {
+			if b == 0 {
+				return string(append(out, chunk[:i]...)), nil
+			}
+		}
+		out = append(out, chunk...)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,175 @@
+// Package blocktrace records the ordered basic blocks an input executes and reads and writes
+// them as text or compact binary trace files
+package blocktrace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Binary layout, little endian
+//
+//	magic "MTCHTRCE" | version u32 | entry count u64
+//	{ uvarint nanoseconds since the previous entry | varint offset minus the previous offset }
+//
+// Text traces have a "# matcha trace" line then one "counter nanoseconds 0xoffset" line per
+// entry, nanoseconds counted from the start of the trace
+const (
+	fileMagic   = "MTCHTRCE"
+	fileVersion = 1
+	textHeader  = "# matcha trace"
+)
+
+var ErrBadTraceFile = errors.New("not a matcha trace file")
+
+// Entry is one executed block, Offset is from the base address like in the blocks file
+type Entry struct {
+	Counter uint64
+	Time    time.Duration
+	Offset  uint64
+}
+
+// Recorder collects entries as the blocks are hit
+type Recorder struct {
+	Entries []Entry
+	start   time.Time
+}
+
+// Record adds the block at offset, the first one starts the clock
+func (r *Recorder) Record(offset uint64) {
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+	r.Entries = append(r.Entries, Entry{Counter: uint64(len(r.Entries)), Time: time.Since(r.start), Offset: offset})
+}
+
+// Save writes the entries as a binary trace or, when text is set, a text trace
+func Save(path string, entries []Entry, text bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if text {
+		fmt.Fprintln(w, textHeader)
+		for _, entry := range entries {
+			fmt.Fprintf(w, "%d %d 0x%x\n", entry.Counter, entry.Time.Nanoseconds(), entry.Offset)
+		}
+		return w.Flush()
+	}
+	w.WriteString(fileMagic)
+	binary.Write(w, binary.LittleEndian, uint32(fileVersion))
+	binary.Write(w, binary.LittleEndian, uint64(len(entries)))
+	var last Entry
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, entry := range entries {
+		w.Write(buf[:binary.PutUvarint(buf, uint64(entry.Time-last.Time))])
+		w.Write(buf[:binary.PutVarint(buf, int64(entry.Offset-last.Offset))])
+		last = entry
+	}
+	return w.Flush()
+}
+
+// Load reads a binary or text trace
+func Load(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte(fileMagic)):
+		return decodeBinary(data[len(fileMagic):])
+	case bytes.HasPrefix(data, []byte(textHeader)):
+		return decodeText(data)
+	}
+	return nil, ErrBadTraceFile
+}
+
+func decodeBinary(data []byte) ([]Entry, error) {
+	r := bytes.NewReader(data)
+	var version uint32
+	var count uint64
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != fileVersion {
+		return nil, fmt.Errorf("trace file version %d, want %d", version, fileVersion)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, min(count, uint64(len(data))))
+	var last Entry
+	for i := uint64(0); i < count; i++ {
+		delta, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, unexpected(err))
+		}
+		offset, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, unexpected(err))
+		}
+		last = Entry{Counter: i, Time: last.Time + time.Duration(delta), Offset: last.Offset + uint64(offset)}
+		entries = append(entries, last)
+	}
+	return entries, nil
+}
+
+func decodeText(data []byte) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: want counter, nanoseconds and offset", n)
+		}
+		counter, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		nanos, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		offset, err := strconv.ParseUint(fields[2], 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		entries = append(entries, Entry{Counter: counter, Time: time.Duration(nanos), Offset: offset})
+	}
+	return entries, scanner.Err()
+}
+
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Split is the index of the first entry where the blocks of a and b differ, the length of
+// the shorter one when it is a prefix of the other and -1 when both are the same
+func Split(a []Entry, b []Entry) int {
+	for i := 0; i < min(len(a), len(b)); i++ {
+		if a[i].Offset != b[i].Offset {
+			return i
+		}
+	}
+	if len(a) == len(b) {
+		return -1
+	}
+	return min(len(a), len(b))
+}
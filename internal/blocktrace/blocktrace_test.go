@@ -0,0 +1,101 @@
+package blocktrace
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testEntries jumps backwards as often as forwards, so the offset deltas go negative
+func testEntries() []Entry {
+	offsets := []uint64{0x11e7, 0x1200, 0x1100, 0x11e7, 0x0, 0x5000, 0x4fff, 0x11e7}
+	entries := make([]Entry, len(offsets))
+	for i, offset := range offsets {
+		entries[i] = Entry{Counter: uint64(i), Time: time.Duration(i*i) * 150 * time.Nanosecond, Offset: offset}
+	}
+	return entries
+}
+
+func TestSaveLoad(t *testing.T) {
+	for _, text := range []bool{false, true} {
+		name := "binary"
+		if text {
+			name = "text"
+		}
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "trace")
+			entries := testEntries()
+			if err := Save(path, entries, text); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(loaded, entries) {
+				t.Errorf("Load = %+v, want %+v", loaded, entries)
+			}
+		})
+	}
+}
+
+func TestLoadBadFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "trace")
+	if err := Save(path, testEntries(), false); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(dir, "truncated")
+	if err := os.WriteFile(truncated, data[:len(data)-2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(truncated); err == nil {
+		t.Error("Load of a truncated trace succeeded")
+	}
+	other := filepath.Join(dir, "other")
+	if err := os.WriteFile(other, []byte("0x11e7\n0x1200\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(other); !errors.Is(err, ErrBadTraceFile) {
+		t.Errorf("Load = %v, want %v", err, ErrBadTraceFile)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	trace := func(offsets ...uint64) []Entry {
+		entries := make([]Entry, len(offsets))
+		for i, offset := range offsets {
+			entries[i] = Entry{Counter: uint64(i), Offset: offset}
+		}
+		return entries
+	}
+	tests := []struct {
+		name string
+		a, b []Entry
+		want int
+	}{
+		{"identical", trace(1, 2, 3), trace(1, 2, 3), -1},
+		{"both empty", nil, nil, -1},
+		{"a is a prefix of b", trace(1, 2), trace(1, 2, 3, 4), 2},
+		{"b is a prefix of a", trace(1, 2, 3), trace(1), 1},
+		{"empty prefix", nil, trace(1), 0},
+		{"split in the middle", trace(1, 2, 3, 4), trace(1, 2, 5, 4), 2},
+		{"split at the start", trace(7, 2), trace(1, 2), 0},
+		// timing and counters don't matter, only the blocks
+		{"same blocks at other times", []Entry{{Counter: 0, Time: 5, Offset: 1}}, []Entry{{Counter: 3, Time: 9, Offset: 1}}, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Split(test.a, test.b); got != test.want {
+				t.Errorf("Split = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,396 @@
+package exploitable
+
+import (
+	"encoding/binary"
+	"syscall"
+)
+
+// access is what the faulting instruction does with its memory operand
+type access int
+
+const (
+	accessUnknown access = iota
+	// the instruction has no memory operand
+	accessNone
+	accessRead
+	accessWrite
+	// call or jmp through memory or a register
+	accessBranch
+	// rep movs, reads rsi and writes rdi
+	accessBlockMove
+	// push and call write below the stack pointer
+	accessPush
+	// ret reads the return address
+	accessReturn
+	// ud2
+	accessTrap
+)
+
+// instruction is the little the classifier needs of the faulting instruction. address is
+// the effective address of the memory operand when memory is set
+type instruction struct {
+	access  access
+	memory  bool
+	address uint64
+}
+
+// decoder state of one instruction
+type decoder struct {
+	code    []byte
+	i       int
+	regs    *syscall.PtraceRegs
+	opsize  bool
+	addr32  bool
+	rep     byte
+	segment uint64
+	rexX    bool
+	rexB    bool
+}
+
+// decode works out what the x86-64 instruction at the start of code accesses. It covers the
+// general purpose, SSE, VEX and EVEX moves compilers and libc emit, anything else comes back
+// as accessUnknown. EVEX compressed displacements are taken unscaled
+func decode(code []byte, regs *syscall.PtraceRegs) instruction {
+	d := &decoder{code: code, regs: regs}
+	inst, ok := d.decode()
+	if !ok {
+		return instruction{access: accessUnknown}
+	}
+	return inst
+}
+
+func (d *decoder) next() (byte, bool) {
+	if d.i >= len(d.code) {
+		return 0, false
+	}
+	b := d.code[d.i]
+	d.i++
+	return b, true
+}
+
+func (d *decoder) decode() (instruction, bool) {
+	var b byte
+	var ok bool
+prefixes:
+	for {
+		if b, ok = d.next(); !ok {
+			return instruction{}, false
+		}
+		switch b {
+		case 0x66:
+			d.opsize = true
+		case 0x67:
+			d.addr32 = true
+		case 0xF2, 0xF3:
+			d.rep = b
+		case 0x64:
+			d.segment = d.regs.Fs_base
+		case 0x65:
+			d.segment = d.regs.Gs_base
+		case 0xF0, 0x26, 0x2E, 0x36, 0x3E:
+		default:
+			break prefixes
+		}
+	}
+	if b&0xF0 == 0x40 {
+		d.rexX, d.rexB = b&2 != 0, b&1 != 0
+		if b, ok = d.next(); !ok {
+			return instruction{}, false
+		}
+	}
+	switch b {
+	case 0xC5:
+		// two byte VEX, always map 0F
+		p, ok := d.next()
+		if !ok {
+			return instruction{}, false
+		}
+		d.impliedPrefix(p)
+		return d.mapped(1)
+	case 0xC4:
+		p0, ok := d.next()
+		if !ok {
+			return instruction{}, false
+		}
+		p1, ok := d.next()
+		if !ok {
+			return instruction{}, false
+		}
+		d.rexX, d.rexB = p0&0x40 == 0, p0&0x20 == 0
+		d.impliedPrefix(p1)
+		return d.mapped(int(p0 & 0x1F))
+	case 0x62:
+		if d.i+3 > len(d.code) {
+			return instruction{}, false
+		}
+		p0, p1 := d.code[d.i], d.code[d.i+1]
+		d.i += 3
+		d.rexX, d.rexB = p0&0x40 == 0, p0&0x20 == 0
+		d.impliedPrefix(p1)
+		return d.mapped(int(p0 & 0x7))
+	case 0x0F:
+		escape, ok := d.next()
+		if !ok {
+			return instruction{}, false
+		}
+		switch escape {
+		case 0x38:
+			return d.mapped(2)
+		case 0x3A:
+			return d.mapped(3)
+		}
+		d.i--
+		return d.mapped(1)
+	}
+	return d.oneByte(b)
+}
+
+// impliedPrefix takes the pp field of a VEX or EVEX prefix byte, the 66, F3 or F2 prefix it
+// stands for
+func (d *decoder) impliedPrefix(p byte) {
+	switch p & 3 {
+	case 1:
+		d.opsize = true
+	case 2:
+		d.rep = 0xF3
+	case 3:
+		d.rep = 0xF2
+	}
+}
+
+// immediate size of instructions taking a full size one
+func (d *decoder) immFull() int {
+	if d.opsize {
+		return 2
+	}
+	return 4
+}
+
+func (d *decoder) oneByte(op byte) (instruction, bool) {
+	rsp := d.regs.Rsp
+	switch {
+	case op < 0x40 && op&7 < 4:
+		// add, or, adc, sbb, and, sub, xor, cmp with a memory operand
+		switch {
+		case op&7 >= 2 || op>>3 == 7:
+			return d.modrm(accessRead, 0)
+		default:
+			return d.modrm(accessWrite, 0)
+		}
+	case op >= 0x50 && op <= 0x57, op == 0x68, op == 0x6A, op == 0x9C, op == 0xC8, op == 0xE8:
+		return instruction{access: accessPush, memory: true, address: rsp - 8}, true
+	case op >= 0x58 && op <= 0x5F, op == 0x9D:
+		return instruction{access: accessRead, memory: true, address: rsp}, true
+	case op == 0xC9:
+		return instruction{access: accessRead, memory: true, address: d.regs.Rbp}, true
+	case op == 0xC2, op == 0xC3, op == 0xCA, op == 0xCB:
+		return instruction{access: accessReturn, memory: true, address: rsp}, true
+	case op == 0x63, op == 0x84, op == 0x85, op == 0x8A, op == 0x8B, op == 0x8E, op >= 0xD8 && op <= 0xDF:
+		return d.modrm(accessRead, 0)
+	case op == 0x69:
+		return d.modrm(accessRead, d.immFull())
+	case op == 0x6B:
+		return d.modrm(accessRead, 1)
+	case op >= 0x86 && op <= 0x89, op == 0x8C, op == 0x8F, op >= 0xD0 && op <= 0xD3, op == 0xFE:
+		return d.modrm(accessWrite, 0)
+	case op == 0x8D:
+		// lea computes an address without touching it
+		return d.modrm(accessNone, 0)
+	case op == 0xC0, op == 0xC1, op == 0xC6:
+		return d.modrm(accessWrite, 1)
+	case op == 0xC7:
+		return d.modrm(accessWrite, d.immFull())
+	case op == 0x80, op == 0x81, op == 0x83:
+		imm := 1
+		if op == 0x81 {
+			imm = d.immFull()
+		}
+		if d.reg() == 7 {
+			return d.modrm(accessRead, imm)
+		}
+		return d.modrm(accessWrite, imm)
+	case op == 0xF6, op == 0xF7:
+		switch d.reg() {
+		case 0, 1:
+			imm := 1
+			if op == 0xF7 {
+				imm = d.immFull()
+			}
+			return d.modrm(accessRead, imm)
+		case 2, 3:
+			return d.modrm(accessWrite, 0)
+		}
+		return d.modrm(accessRead, 0)
+	case op == 0xFF:
+		switch d.reg() {
+		case 0, 1:
+			return d.modrm(accessWrite, 0)
+		case 2, 3, 4, 5:
+			return d.modrm(accessBranch, 0)
+		}
+		return d.modrm(accessRead, 0)
+	case op == 0xA4, op == 0xA5:
+		return instruction{access: accessBlockMove, memory: true, address: d.regs.Rdi}, true
+	case op == 0xAA, op == 0xAB:
+		return instruction{access: accessWrite, memory: true, address: d.regs.Rdi}, true
+	case op == 0xAC, op == 0xAD, op == 0xA6, op == 0xA7:
+		return instruction{access: accessRead, memory: true, address: d.regs.Rsi}, true
+	case op == 0xAE, op == 0xAF:
+		return instruction{access: accessRead, memory: true, address: d.regs.Rdi}, true
+	}
+	return instruction{access: accessUnknown}, true
+}
+
+// mapped decodes an opcode of the 0F, 0F38 and 0F3A maps, legacy or VEX and EVEX encoded
+func (d *decoder) mapped(opcodeMap int) (instruction, bool) {
+	op, ok := d.next()
+	if !ok {
+		return instruction{}, false
+	}
+	switch opcodeMap {
+	case 1:
+		switch {
+		case op == 0x0B:
+			return instruction{access: accessTrap}, true
+		case op == 0x05, op >= 0x06 && op <= 0x09, op >= 0x30 && op <= 0x35, op == 0x77,
+			op >= 0x80 && op <= 0x8F, op == 0xA0, op == 0xA1, op == 0xA2, op == 0xA8, op == 0xA9,
+			op >= 0xC8 && op <= 0xCF:
+			return instruction{access: accessNone}, true
+		case op == 0x0D, op >= 0x18 && op <= 0x1F:
+			// prefetches and hint nops never fault
+			return d.modrm(accessNone, 0)
+		case op == 0x7E:
+			if d.rep == 0xF3 {
+				return d.modrm(accessRead, 0)
+			}
+			return d.modrm(accessWrite, 0)
+		case op == 0xA4, op == 0xAC:
+			return d.modrm(accessWrite, 1)
+		case op == 0xBA:
+			if d.reg() == 4 {
+				return d.modrm(accessRead, 1)
+			}
+			return d.modrm(accessWrite, 1)
+		case op == 0xAE:
+			switch d.reg() {
+			case 0, 3, 4:
+				return d.modrm(accessWrite, 0)
+			}
+			return d.modrm(accessRead, 0)
+		case op == 0x11, op == 0x13, op == 0x17, op == 0x29, op == 0x2B, op == 0x7F,
+			op >= 0x90 && op <= 0x9F, op == 0xA5, op == 0xAB, op == 0xAD, op == 0xB0, op == 0xB1,
+			op == 0xB3, op == 0xBB, op == 0xC0, op == 0xC1, op == 0xC3, op == 0xC7, op == 0xD6, op == 0xE7:
+			return d.modrm(accessWrite, 0)
+		case op >= 0x70 && op <= 0x73, op == 0xC2, op >= 0xC4 && op <= 0xC6:
+			return d.modrm(accessRead, 1)
+		}
+		return d.modrm(accessRead, 0)
+	case 2:
+		switch {
+		case op == 0xF1 && d.rep != 0xF2, op == 0x2E, op == 0x2F, op == 0x8E, op >= 0xA0 && op <= 0xA3:
+			return d.modrm(accessWrite, 0)
+		}
+		return d.modrm(accessRead, 0)
+	case 3:
+		switch op {
+		case 0x14, 0x15, 0x16, 0x17, 0x19, 0x1B, 0x1D, 0x39, 0x3B:
+			return d.modrm(accessWrite, 1)
+		}
+		return d.modrm(accessRead, 1)
+	}
+	return d.modrm(accessRead, 0)
+}
+
+// reg is the reg field of the ModRM byte at the current position, the opcode extension of
+// group instructions
+func (d *decoder) reg() byte {
+	if d.i >= len(d.code) {
+		return 0xFF
+	}
+	return (d.code[d.i] >> 3) & 7
+}
+
+// gpr is a general purpose register by its encoding number
+func (d *decoder) gpr(n byte) uint64 {
+	r := d.regs
+	return [16]uint64{r.Rax, r.Rcx, r.Rdx, r.Rbx, r.Rsp, r.Rbp, r.Rsi, r.Rdi,
+		r.R8, r.R9, r.R10, r.R11, r.R12, r.R13, r.R14, r.R15}[n&15]
+}
+
+// modrm decodes the ModRM operand and the displacement and immediate after it, a register
+// operand means the instruction touches no memory
+func (d *decoder) modrm(kind access, immSize int) (instruction, bool) {
+	modrm, ok := d.next()
+	if !ok {
+		return instruction{}, false
+	}
+	mod, rm := modrm>>6, modrm&7
+	if mod == 3 {
+		// a call or jmp to a register faults on the target, not on memory
+		if kind == accessBranch {
+			return instruction{access: accessBranch}, true
+		}
+		return instruction{access: accessNone}, true
+	}
+	var address uint64
+	ripRelative := false
+	dispSize := 0
+	switch {
+	case rm == 4:
+		sib, ok := d.next()
+		if !ok {
+			return instruction{}, false
+		}
+		index := (sib >> 3) & 7
+		if d.rexX {
+			index |= 8
+		}
+		if index != 4 {
+			address += d.gpr(index) << (sib >> 6)
+		}
+		base := sib & 7
+		if base == 5 && mod == 0 {
+			dispSize = 4
+		} else {
+			if d.rexB {
+				base |= 8
+			}
+			address += d.gpr(base)
+		}
+	case rm == 5 && mod == 0:
+		ripRelative = true
+		dispSize = 4
+	default:
+		if d.rexB {
+			rm |= 8
+		}
+		address = d.gpr(rm)
+	}
+	switch mod {
+	case 1:
+		dispSize = 1
+	case 2:
+		dispSize = 4
+	}
+	if d.i+dispSize+immSize > len(d.code) {
+		return instruction{}, false
+	}
+	switch dispSize {
+	case 1:
+		address += uint64(int64(int8(d.code[d.i])))
+	case 4:
+		address += uint64(int64(int32(binary.LittleEndian.Uint32(d.code[d.i:]))))
+	}
+	d.i += dispSize + immSize
+	if ripRelative {
+		address += d.regs.Rip + uint64(d.i)
+	}
+	address += d.segment
+	if d.addr32 {
+		address &= 0xFFFFFFFF
+	}
+	if kind == accessNone {
+		return instruction{access: accessNone}, true
+	}
+	return instruction{access: kind, memory: true, address: address}, true
+}
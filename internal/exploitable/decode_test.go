@@ -0,0 +1,76 @@
+package exploitable
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	regs := syscall.PtraceRegs{
+		Rax:     0x1000,
+		Rbx:     0x20,
+		Rsp:     0x7ffc0000,
+		Rbp:     0x7ffc0100,
+		Rsi:     0x5000,
+		Rdi:     0x6000,
+		R9:      0x3,
+		Rip:     0x401000,
+		Fs_base: 0x7ffff7d80740,
+	}
+	tests := []struct {
+		name    string
+		code    []byte
+		access  access
+		memory  bool
+		address uint64
+	}{
+		{"mov store", []byte{0x89, 0x08}, accessWrite, true, 0x1000},
+		{"mov load disp8", []byte{0x8B, 0x48, 0x08}, accessRead, true, 0x1008},
+		{"mov register", []byte{0x89, 0xC8}, accessNone, false, 0},
+		{"add store", []byte{0x01, 0x07}, accessWrite, true, 0x6000},
+		{"cmp load", []byte{0x39, 0x07}, accessRead, true, 0x6000},
+		{"lea", []byte{0x8D, 0x04, 0x08}, accessNone, false, 0},
+		{"push", []byte{0x55}, accessPush, true, 0x7ffc0000 - 8},
+		{"call rel32", []byte{0xE8, 0x00, 0x00, 0x00, 0x00}, accessPush, true, 0x7ffc0000 - 8},
+		{"pop", []byte{0x5D}, accessRead, true, 0x7ffc0000},
+		{"leave", []byte{0xC9}, accessRead, true, 0x7ffc0100},
+		{"ret", []byte{0xC3}, accessReturn, true, 0x7ffc0000},
+		{"call through memory", []byte{0xFF, 0x50, 0x08}, accessBranch, true, 0x1008},
+		{"call through register", []byte{0xFF, 0xD0}, accessBranch, false, 0},
+		{"rep movsb", []byte{0xF3, 0xA4}, accessBlockMove, true, 0x6000},
+		{"rep stosq", []byte{0xF3, 0x48, 0xAB}, accessWrite, true, 0x6000},
+		{"lodsb", []byte{0xAC}, accessRead, true, 0x5000},
+		{"ud2", []byte{0x0F, 0x0B}, accessTrap, false, 0},
+		// mov eax, [rip+0x10] is relative to the end of the instruction
+		{"rip relative", []byte{0x8B, 0x05, 0x10, 0x00, 0x00, 0x00}, accessRead, true, 0x401000 + 6 + 0x10},
+		// mov [rax+rbx*4+0x10], edx
+		{"sib with index", []byte{0x89, 0x54, 0x98, 0x10}, accessWrite, true, 0x1000 + 0x20*4 + 0x10},
+		// mov rax, [rdi+r9*8]
+		{"sib with rex index", []byte{0x4A, 0x8B, 0x04, 0xCF}, accessRead, true, 0x6000 + 3*8},
+		// mov rax, fs:[0x28], the stack canary
+		{"fs segment", []byte{0x64, 0x48, 0x8B, 0x04, 0x25, 0x28, 0x00, 0x00, 0x00}, accessRead, true, 0x7ffff7d80740 + 0x28},
+		{"address size prefix", []byte{0x67, 0x8B, 0x00}, accessRead, true, 0x1000},
+		{"mov immediate store", []byte{0xC7, 0x40, 0x04, 0x01, 0x00, 0x00, 0x00}, accessWrite, true, 0x1004},
+		{"cmp immediate", []byte{0x83, 0x78, 0x04, 0x00}, accessRead, true, 0x1004},
+		{"sse store", []byte{0x0F, 0x11, 0x07}, accessWrite, true, 0x6000},
+		{"sse load", []byte{0x66, 0x0F, 0x6F, 0x06}, accessRead, true, 0x5000},
+		// vmovdqu [rdi], ymm0
+		{"vex store", []byte{0xC5, 0xFE, 0x7F, 0x07}, accessWrite, true, 0x6000},
+		// vmovdqu ymm0, [rsi]
+		{"vex load", []byte{0xC5, 0xFE, 0x6F, 0x06}, accessRead, true, 0x5000},
+		// vpcmpeqb ymm1, ymm0, [rdi+0x20] in the 3 byte form
+		{"three byte vex load", []byte{0xC4, 0xE1, 0x7D, 0x74, 0x4F, 0x20}, accessRead, true, 0x6020},
+		{"truncated", []byte{0x8B}, accessUnknown, false, 0},
+		{"truncated displacement", []byte{0x8B, 0x80, 0x00}, accessUnknown, false, 0},
+		{"empty", nil, accessUnknown, false, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := decode(test.code, &regs)
+			want := instruction{access: test.access, memory: test.memory, address: test.address}
+			if got != want {
+				t.Errorf("decode(% x) = %+v, want %+v", test.code, got, want)
+			}
+		})
+	}
+}
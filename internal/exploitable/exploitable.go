@@ -0,0 +1,289 @@
+// Package exploitable rates how likely a crash is to be exploitable from the faulting
+// instruction, the registers, the stack and what the target wrote to stderr, with
+// heuristics in the spirit of !exploitable and CERT's exploitable for gdb
+package exploitable
+
+import (
+	"bytes"
+	"fmt"
+	"matcha/internal/sanitizer"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// Rating is how likely a crash is to be exploitable, more dangerous ones are smaller so
+// they sort first
+type Rating int
+
+const (
+	Exploitable Rating = iota
+	ProbablyExploitable
+	Unknown
+	ProbablyNotExploitable
+)
+
+func (r Rating) String() string {
+	switch r {
+	case Exploitable:
+		return "EXPLOITABLE"
+	case ProbablyExploitable:
+		return "PROBABLY_EXPLOITABLE"
+	case ProbablyNotExploitable:
+		return "PROBABLY_NOT_EXPLOITABLE"
+	}
+	return "UNKNOWN"
+}
+
+// Classification is the rating of a crash and why
+type Classification struct {
+	Rating Rating
+	// Kind names the class of crash, like DestAv for a write to a bad address, or the
+	// sanitizer error
+	Kind        string
+	Description string
+}
+
+func (c Classification) String() string {
+	return fmt.Sprintf("%s %s: %s", c.Rating, c.Kind, c.Description)
+}
+
+// Crash is what a crash is classified from. Fault is nil for a sanitizer build that exited
+// after its report
+type Crash struct {
+	Signal    syscall.Signal
+	Fault     *Fault
+	Sanitizer *sanitizer.Report
+	Stderr    []byte
+	// Frames are the return addresses found on the stack, from Symbolizer.Backtrace
+	Frames []Frame
+}
+
+// Fault is the state of the crashed process when it got the signal
+type Fault struct {
+	Regs syscall.PtraceRegs
+	// Address and Code are si_addr and si_code of the signal
+	Address uint64
+	Code    int32
+	// Instruction are the bytes at pc, empty when pc isn't mapped
+	Instruction []byte
+	// Stack are the words from the stack pointer up
+	Stack    []uint64
+	Mappings []Mapping
+}
+
+// Mapping is a mapped range of the crashed process, Path is empty for anonymous memory
+type Mapping struct {
+	Start      uint64
+	End        uint64
+	Offset     uint64
+	Executable bool
+	Path       string
+}
+
+// mappingAt is the mapping containing address, nil when it isn't mapped
+func (f *Fault) mappingAt(address uint64) *Mapping {
+	for i := range f.Mappings {
+		if address >= f.Mappings[i].Start && address < f.Mappings[i].End {
+			return &f.Mappings[i]
+		}
+	}
+	return nil
+}
+
+// addresses below this are near null, nothing can be mapped there with the default
+// vm.mmap_min_addr
+const nullRange = 0x10000
+
+// faults this close to the stack pointer on unmapped memory ran off the end of the stack
+const stackRange = 0x10000
+
+// si_code of a general protection fault, si_addr is 0 rather than the address
+const siKernel = 0x80
+
+// si_code values of SIGFPE
+const (
+	fpeIntDiv = 1
+	fpeIntOvf = 2
+)
+
+var (
+	// glibc's malloc_printerr messages, free(): double free detected in tcache 2
+	heapErrorPattern = regexp.MustCompile(`(?m)^(?:\w+\(\): .*|double free or corruption.*|corrupted size vs\. prev_size.*|corrupted double-linked list.*)$`)
+	// crash: crash.c:5: main: Assertion `x' failed.
+	assertionPattern = regexp.MustCompile(`(?m)^.*Assertion .* failed\.?$`)
+)
+
+// Classify rates a crash, a sanitizer report takes precedence over the signal
+func Classify(crash Crash) Classification {
+	if crash.Sanitizer != nil {
+		if c, ok := classifySanitizer(crash.Sanitizer); ok {
+			return c
+		}
+	}
+	if crash.Fault == nil {
+		return Classification{Rating: Unknown, Kind: "UncategorizedSignal", Description: fmt.Sprintf("%v without a fault to look at", crash.Signal)}
+	}
+	fault := crash.Fault
+	pc := fault.Regs.Rip
+	if crash.Signal != syscall.SIGABRT {
+		if m := fault.mappingAt(pc); m == nil || !m.Executable {
+			if pc < nullRange {
+				return Classification{Rating: ProbablyNotExploitable, Kind: "PcNearNull", Description: fmt.Sprintf("pc 0x%x near null, a call through a null function pointer", pc)}
+			}
+			where := "unmapped memory"
+			if m != nil {
+				where = fmt.Sprintf("non executable memory %s", mappingName(m))
+			}
+			return Classification{Rating: Exploitable, Kind: "BadPC", Description: fmt.Sprintf("pc 0x%x in %s", pc, where)}
+		}
+	}
+	switch crash.Signal {
+	case syscall.SIGABRT:
+		return classifyAbort(crash)
+	case syscall.SIGFPE:
+		description := "floating point exception"
+		switch fault.Code {
+		case fpeIntDiv:
+			description = "integer division by zero"
+		case fpeIntOvf:
+			description = "integer overflow in a division"
+		}
+		return Classification{Rating: ProbablyNotExploitable, Kind: "FloatingPointException", Description: description}
+	case syscall.SIGILL:
+		if decode(fault.Instruction, &fault.Regs).access == accessTrap {
+			return Classification{Rating: ProbablyNotExploitable, Kind: "Trap", Description: "ud2, a trap the compiler put there"}
+		}
+		return Classification{Rating: Exploitable, Kind: "BadInstruction", Description: fmt.Sprintf("illegal instruction at 0x%x, pc likely went astray", pc)}
+	case syscall.SIGSEGV, syscall.SIGBUS:
+		return classifyAccess(fault)
+	}
+	return Classification{Rating: Unknown, Kind: "UncategorizedSignal", Description: crash.Signal.String()}
+}
+
+// classifyAccess rates a memory access fault by what the instruction did and where
+func classifyAccess(fault *Fault) Classification {
+	inst := decode(fault.Instruction, &fault.Regs)
+	address, known := fault.Address, fault.Code != siKernel
+	if !known && inst.memory {
+		address, known = inst.address, true
+	}
+	nearNull := known && address < nullRange
+	at := "a non canonical address"
+	if known {
+		at = fmt.Sprintf("0x%x", address)
+	}
+	rsp := fault.Regs.Rsp
+	if known && fault.mappingAt(address) == nil && address+stackRange > rsp && address < rsp+stackRange {
+		return Classification{Rating: ProbablyNotExploitable, Kind: "StackExhaustion", Description: fmt.Sprintf("access to %s next to the stack pointer 0x%x, likely unbounded recursion", at, rsp)}
+	}
+	if inst.access == accessPush {
+		return Classification{Rating: ProbablyNotExploitable, Kind: "StackExhaustion", Description: fmt.Sprintf("push or call faulted at %s", at)}
+	}
+	switch inst.access {
+	case accessReturn:
+		return Classification{Rating: Exploitable, Kind: "ReturnAv", Description: fmt.Sprintf("ret faulted with the stack pointer at 0x%x, the return address or the stack pointer is corrupt", rsp)}
+	case accessBranch:
+		if !inst.memory {
+			return Classification{Rating: Exploitable, Kind: "BranchAv", Description: "call or jmp to a non canonical address in a register"}
+		}
+		if nearNull {
+			return Classification{Rating: ProbablyExploitable, Kind: "BranchAvNearNull", Description: fmt.Sprintf("call or jmp through a pointer read near null at %s", at)}
+		}
+		return Classification{Rating: Exploitable, Kind: "BranchAv", Description: fmt.Sprintf("call or jmp through a pointer read from %s", at)}
+	case accessBlockMove:
+		if nearNull {
+			return Classification{Rating: ProbablyExploitable, Kind: "BlockMoveAvNearNull", Description: fmt.Sprintf("rep movs faulted near null at %s", at)}
+		}
+		return Classification{Rating: Exploitable, Kind: "BlockMoveAv", Description: fmt.Sprintf("rep movs faulted at %s", at)}
+	case accessWrite:
+		if nearNull {
+			return Classification{Rating: ProbablyExploitable, Kind: "DestAvNearNull", Description: fmt.Sprintf("write near null at %s", at)}
+		}
+		return Classification{Rating: Exploitable, Kind: "DestAv", Description: fmt.Sprintf("write to %s", at)}
+	case accessRead:
+		if nearNull {
+			return Classification{Rating: ProbablyNotExploitable, Kind: "SourceAvNearNull", Description: fmt.Sprintf("read near null at %s", at)}
+		}
+		return Classification{Rating: Unknown, Kind: "SourceAv", Description: fmt.Sprintf("read from %s", at)}
+	}
+	if nearNull {
+		return Classification{Rating: ProbablyNotExploitable, Kind: "AccessViolationNearNull", Description: fmt.Sprintf("access near null at %s by an instruction not decoded", at)}
+	}
+	return Classification{Rating: Unknown, Kind: "AccessViolation", Description: fmt.Sprintf("access to %s by an instruction not decoded", at)}
+}
+
+// classifyAbort looks for the glibc checks that abort on memory corruption, in the stack
+// and in the message they write to stderr
+func classifyAbort(crash Crash) Classification {
+	// __stack_chk_fail reports through __fortify_fail so it is looked for first
+	functions := make(map[string]bool)
+	for _, frame := range crash.Frames {
+		functions[frame.Function] = true
+	}
+	switch {
+	case functions["__stack_chk_fail"]:
+		return Classification{Rating: Exploitable, Kind: "StackBufferOverflow", Description: "stack canary overwritten, __stack_chk_fail on the stack"}
+	case bytes.Contains(crash.Stderr, []byte("*** stack smashing detected ***")):
+		return Classification{Rating: Exploitable, Kind: "StackBufferOverflow", Description: "stack canary overwritten, stack smashing detected"}
+	case functions["__chk_fail"], functions["__fortify_fail"]:
+		return Classification{Rating: Exploitable, Kind: "FortifyFail", Description: "_FORTIFY_SOURCE check failed, __chk_fail on the stack"}
+	case bytes.Contains(crash.Stderr, []byte("*** buffer overflow detected ***")):
+		return Classification{Rating: Exploitable, Kind: "FortifyFail", Description: "_FORTIFY_SOURCE check failed, buffer overflow detected"}
+	case functions["malloc_printerr"]:
+		return Classification{Rating: Exploitable, Kind: "HeapError", Description: "glibc found heap corruption, malloc_printerr on the stack"}
+	}
+	if match := heapErrorPattern.Find(crash.Stderr); match != nil {
+		return Classification{Rating: Exploitable, Kind: "HeapError", Description: fmt.Sprintf("glibc found heap corruption, %s", strings.TrimSpace(string(match)))}
+	}
+	if match := assertionPattern.Find(crash.Stderr); match != nil {
+		return Classification{Rating: Unknown, Kind: "AbortSignal", Description: strings.TrimSpace(string(match))}
+	}
+	return Classification{Rating: Unknown, Kind: "AbortSignal", Description: "abort without a known memory corruption check"}
+}
+
+// classifySanitizer rates the error a sanitizer reported, ok is false for the errors that
+// are better rated from the fault, like a SEGV
+func classifySanitizer(report *sanitizer.Report) (Classification, bool) {
+	kind := report.Type
+	if report.Access != "" {
+		kind += "-" + strings.ToLower(report.Access)
+	}
+	description := fmt.Sprintf("%s reported %s", report.Tool, report.Type)
+	if report.Access != "" {
+		description += " on a " + strings.ToLower(report.Access)
+	}
+	rating := Unknown
+	switch report.Type {
+	case "heap-use-after-free", "stack-use-after-return", "stack-use-after-scope", "use-after-poison",
+		"attempting double-free", "attempting free", "bad-free", "double-free", "alloc-dealloc-mismatch",
+		"new-delete-type-mismatch":
+		rating = Exploitable
+	case "heap-buffer-overflow", "stack-buffer-overflow", "stack-buffer-underflow", "global-buffer-overflow",
+		"dynamic-stack-buffer-overflow", "container-overflow", "intra-object-overflow", "wild-addr-write",
+		"unknown-crash":
+		rating = ProbablyExploitable
+		if report.Access == "WRITE" {
+			rating = Exploitable
+		}
+	// "requested allocation size exceeds" and LeakSanitizer's "detected memory leaks" are
+	// cut at their first word by the header pattern
+	case "stack-overflow", "allocation-size-too-big", "out-of-memory", "rss-limit-exceeded",
+		"calloc-overflow", "requested", "detected":
+		rating = ProbablyNotExploitable
+	case "SEGV", "BUS", "FPE", "ILL", "ABRT":
+		return Classification{}, false
+	default:
+		if strings.Contains(report.Type, "null pointer") {
+			rating = ProbablyNotExploitable
+		}
+	}
+	return Classification{Rating: rating, Kind: strings.ReplaceAll(kind, " ", "-"), Description: description}, true
+}
+
+func mappingName(m *Mapping) string {
+	if m.Path == "" {
+		return "(anonymous)"
+	}
+	return m.Path
+}
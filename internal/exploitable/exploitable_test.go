@@ -0,0 +1,194 @@
+package exploitable
+
+import (
+	"matcha/internal/sanitizer"
+	"syscall"
+	"testing"
+)
+
+const (
+	testText  = 0x401000
+	testHeap  = 0x4c0000
+	testStack = 0x7ffc0000
+)
+
+// testFault is a fault at pc in the text of a small process, with inst there
+func testFault(pc uint64, inst []byte, address uint64) *Fault {
+	return &Fault{
+		Regs:        syscall.PtraceRegs{Rip: pc, Rsp: testStack + 0x800},
+		Address:     address,
+		Code:        1,
+		Instruction: inst,
+		Mappings: []Mapping{
+			{Start: 0x400000, End: 0x401000, Path: "/bin/target"},
+			{Start: testText, End: 0x402000, Executable: true, Offset: 0x1000, Path: "/bin/target"},
+			{Start: testHeap, End: 0x4e1000, Path: "[heap]"},
+			{Start: testStack, End: 0x7ffe1000, Path: "[stack]"},
+		},
+	}
+}
+
+func withCode(fault *Fault, code int32) *Fault {
+	fault.Code = code
+	return fault
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name   string
+		crash  Crash
+		rating Rating
+		kind   string
+	}{
+		{
+			name:   "write to a non null address",
+			crash:  Crash{Signal: syscall.SIGSEGV, Fault: testFault(testText, []byte{0x89, 0x08}, 0x4141414141410000)},
+			rating: Exploitable,
+			kind:   "DestAv",
+		},
+		{
+			name:   "write near null",
+			crash:  Crash{Signal: syscall.SIGSEGV, Fault: testFault(testText, []byte{0x89, 0x08}, 0x10)},
+			rating: ProbablyExploitable,
+			kind:   "DestAvNearNull",
+		},
+		{
+			name:   "read near null",
+			crash:  Crash{Signal: syscall.SIGSEGV, Fault: testFault(testText, []byte{0x8B, 0x48, 0x08}, 0x8)},
+			rating: ProbablyNotExploitable,
+			kind:   "SourceAvNearNull",
+		},
+		{
+			name:   "read from a wild address",
+			crash:  Crash{Signal: syscall.SIGSEGV, Fault: testFault(testText, []byte{0x8B, 0x48, 0x08}, 0x4141414141410000)},
+			rating: Unknown,
+			kind:   "SourceAv",
+		},
+		{
+			name:   "pc in non executable memory",
+			crash:  Crash{Signal: syscall.SIGSEGV, Fault: testFault(testHeap+0x10, nil, testHeap+0x10)},
+			rating: Exploitable,
+			kind:   "BadPC",
+		},
+		{
+			name:   "pc in unmapped memory",
+			crash:  Crash{Signal: syscall.SIGSEGV, Fault: testFault(0x4141414141414141, nil, 0x4141414141414141)},
+			rating: Exploitable,
+			kind:   "BadPC",
+		},
+		{
+			name:   "pc near null",
+			crash:  Crash{Signal: syscall.SIGSEGV, Fault: testFault(0, nil, 0)},
+			rating: ProbablyNotExploitable,
+			kind:   "PcNearNull",
+		},
+		{
+			name:   "call through a register to a non canonical address",
+			crash:  Crash{Signal: syscall.SIGSEGV, Fault: withCode(testFault(testText, []byte{0xFF, 0xD0}, 0), siKernel)},
+			rating: Exploitable,
+			kind:   "BranchAv",
+		},
+		{
+			name:   "ret with a corrupt return address",
+			crash:  Crash{Signal: syscall.SIGSEGV, Fault: withCode(testFault(testText, []byte{0xC3}, 0), siKernel)},
+			rating: Exploitable,
+			kind:   "ReturnAv",
+		},
+		{
+			name:   "push past the end of the stack",
+			crash:  Crash{Signal: syscall.SIGSEGV, Fault: testFault(testText, []byte{0x55}, testStack-8)},
+			rating: ProbablyNotExploitable,
+			kind:   "StackExhaustion",
+		},
+		{
+			name:   "rep movs",
+			crash:  Crash{Signal: syscall.SIGSEGV, Fault: testFault(testText, []byte{0xF3, 0xA4}, 0x4e2000)},
+			rating: Exploitable,
+			kind:   "BlockMoveAv",
+		},
+		{
+			name: "stack canary abort",
+			crash: Crash{
+				Signal: syscall.SIGABRT,
+				Fault:  testFault(testText, nil, 0),
+				Frames: []Frame{{Function: "abort"}, {Function: "__fortify_fail"}, {Function: "__stack_chk_fail"}, {Function: "main"}},
+			},
+			rating: Exploitable,
+			kind:   "StackBufferOverflow",
+		},
+		{
+			name:   "stack canary abort from stderr",
+			crash:  Crash{Signal: syscall.SIGABRT, Fault: testFault(testText, nil, 0), Stderr: []byte("*** stack smashing detected ***: terminated\n")},
+			rating: Exploitable,
+			kind:   "StackBufferOverflow",
+		},
+		{
+			name:   "fortify abort",
+			crash:  Crash{Signal: syscall.SIGABRT, Fault: testFault(testText, nil, 0), Stderr: []byte("*** buffer overflow detected ***: terminated\n")},
+			rating: Exploitable,
+			kind:   "FortifyFail",
+		},
+		{
+			name:   "glibc heap abort",
+			crash:  Crash{Signal: syscall.SIGABRT, Fault: testFault(testText, nil, 0), Stderr: []byte("free(): double free detected in tcache 2\n")},
+			rating: Exploitable,
+			kind:   "HeapError",
+		},
+		{
+			name:   "assertion",
+			crash:  Crash{Signal: syscall.SIGABRT, Fault: testFault(testText, nil, 0), Stderr: []byte("target: parse.c:12: parse: Assertion `n < 16' failed.\n")},
+			rating: Unknown,
+			kind:   "AbortSignal",
+		},
+		{
+			name:   "division by zero",
+			crash:  Crash{Signal: syscall.SIGFPE, Fault: withCode(testFault(testText, []byte{0xF7, 0xF9}, testText), fpeIntDiv)},
+			rating: ProbablyNotExploitable,
+			kind:   "FloatingPointException",
+		},
+		{
+			name:   "ud2",
+			crash:  Crash{Signal: syscall.SIGILL, Fault: testFault(testText, []byte{0x0F, 0x0B}, testText)},
+			rating: ProbablyNotExploitable,
+			kind:   "Trap",
+		},
+		{
+			name:   "illegal instruction",
+			crash:  Crash{Signal: syscall.SIGILL, Fault: testFault(testText, []byte{0x06}, testText)},
+			rating: Exploitable,
+			kind:   "BadInstruction",
+		},
+		{
+			name:   "sanitizer heap overflow write",
+			crash:  Crash{Sanitizer: &sanitizer.Report{Tool: "AddressSanitizer", Type: "heap-buffer-overflow", Access: "WRITE"}},
+			rating: Exploitable,
+			kind:   "heap-buffer-overflow-write",
+		},
+		{
+			name:   "sanitizer heap overflow read",
+			crash:  Crash{Sanitizer: &sanitizer.Report{Tool: "AddressSanitizer", Type: "heap-buffer-overflow", Access: "READ"}},
+			rating: ProbablyExploitable,
+			kind:   "heap-buffer-overflow-read",
+		},
+		{
+			name:   "sanitizer SEGV is rated from the fault",
+			crash:  Crash{Signal: syscall.SIGSEGV, Fault: testFault(testText, []byte{0x89, 0x08}, 0x4141414141410000), Sanitizer: &sanitizer.Report{Tool: "AddressSanitizer", Type: "SEGV"}},
+			rating: Exploitable,
+			kind:   "DestAv",
+		},
+		{
+			name:   "signal without a fault",
+			crash:  Crash{Signal: syscall.SIGSEGV},
+			rating: Unknown,
+			kind:   "UncategorizedSignal",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Classify(test.crash)
+			if got.Rating != test.rating || got.Kind != test.kind {
+				t.Errorf("Classify = %s, want %s %s", got, test.rating, test.kind)
+			}
+		})
+	}
+}
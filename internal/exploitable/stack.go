@@ -0,0 +1,109 @@
+package exploitable
+
+import (
+	"debug/elf"
+	"fmt"
+	"matcha/internal/symbols"
+	"path/filepath"
+)
+
+// most frames Backtrace returns
+const maxFrames = 32
+
+// Frame is a code address named by the module and function it is in, Function is empty
+// when the module has no symbol for it
+type Frame struct {
+	Address  uint64
+	Module   string
+	Function string
+	Offset   uint64
+}
+
+func (f Frame) String() string {
+	if f.Function == "" {
+		return fmt.Sprintf("0x%x %s+0x%x", f.Address, filepath.Base(f.Module), f.Offset)
+	}
+	return fmt.Sprintf("0x%x %s+0x%x (%s)", f.Address, f.Function, f.Offset, filepath.Base(f.Module))
+}
+
+// Symbolizer names code addresses of a crashed tracee from the symbols of the files it
+// mapped. Files are read once and kept for later crashes
+type Symbolizer struct {
+	modules map[string]*module
+}
+
+type module struct {
+	start     uint64
+	functions []symbols.Function
+}
+
+func NewSymbolizer() *Symbolizer {
+	return &Symbolizer{modules: make(map[string]*module)}
+}
+
+// Symbolize names address, ok is false when it isn't in a mapped file
+func (s *Symbolizer) Symbolize(fault *Fault, address uint64) (Frame, bool) {
+	return s.symbolize(fault, address, address)
+}
+
+// symbolize names address by the function containing lookup. Return addresses are looked
+// up one byte back, a call to a noreturn function can be the last instruction of its caller
+func (s *Symbolizer) symbolize(fault *Fault, address uint64, lookup uint64) (Frame, bool) {
+	m := fault.mappingAt(address)
+	if m == nil || m.Path == "" || m.Path[0] != '/' {
+		return Frame{}, false
+	}
+	// the file is mapped from its first mapping on, whatever segment address falls in
+	base := m.Start - m.Offset
+	for _, other := range fault.Mappings {
+		if other.Path == m.Path && other.Offset == 0 {
+			base = other.Start
+			break
+		}
+	}
+	frame := Frame{Address: address, Module: m.Path, Offset: address - base}
+	mod := s.load(m.Path)
+	if mod == nil {
+		return frame, true
+	}
+	if function := symbols.FunctionAt(mod.functions, lookup-base+mod.start); function != nil {
+		frame.Function = function.Name
+		frame.Offset = address - base + mod.start - function.Address
+	}
+	return frame, true
+}
+
+// Backtrace are the words on the stack that point into executable code of a mapped file
+// and have a symbol, the return addresses of the calls that led to the crash along with
+// whatever stale ones the scan runs into
+func (s *Symbolizer) Backtrace(fault *Fault) []Frame {
+	frames := make([]Frame, 0)
+	for _, word := range fault.Stack {
+		if m := fault.mappingAt(word); m == nil || !m.Executable {
+			continue
+		}
+		frame, ok := s.symbolize(fault, word, word-1)
+		if !ok || frame.Function == "" {
+			continue
+		}
+		frames = append(frames, frame)
+		if len(frames) == maxFrames {
+			break
+		}
+	}
+	return frames
+}
+
+func (s *Symbolizer) load(path string) *module {
+	if mod, ok := s.modules[path]; ok {
+		return mod
+	}
+	var mod *module
+	if f, err := elf.Open(path); err == nil {
+		start, _ := symbols.ImageRange(f)
+		mod = &module{start: start, functions: symbols.Functions(f)}
+		f.Close()
+	}
+	s.modules[path] = mod
+	return mod
+}
@@ -0,0 +1,107 @@
+// Package sanitizer sets up ASan, UBSan and MSan builds for fuzzing and parses the reports
+// they write to stderr
+package sanitizer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Options make every sanitizer abort on the first error so the tracer sees a SIGABRT, and
+// keep symbolization and leak checking out of the exec loop
+var Options = map[string]string{
+	"ASAN_OPTIONS":  "abort_on_error=1:symbolize=0:detect_leaks=0:allocator_may_return_null=1",
+	"UBSAN_OPTIONS": "halt_on_error=1:abort_on_error=1:print_stacktrace=1:symbolize=0",
+	"MSAN_OPTIONS":  "abort_on_error=1:symbolize=0",
+}
+
+// how many frames of the stack make up a crash bucket
+const bucketFrames = 3
+
+// Env is Options as environment variables. Options already set in matcha's environment come
+// after ours so they win
+func Env() []string {
+	env := make([]string, 0, len(Options))
+	for _, name := range []string{"ASAN_OPTIONS", "UBSAN_OPTIONS", "MSAN_OPTIONS"} {
+		value := Options[name]
+		if own := os.Getenv(name); own != "" {
+			value += ":" + own
+		}
+		env = append(env, name+"="+value)
+	}
+	return env
+}
+
+// Report is a parsed sanitizer error, frames are module+offset as printed without
+// symbolization. Access is READ or WRITE for the memory errors that say which
+type Report struct {
+	Tool   string
+	Type   string
+	Access string
+	Frames []string
+}
+
+var (
+	// ==1234==ERROR: AddressSanitizer: heap-buffer-overflow on address ...
+	// ==1234==ERROR: AddressSanitizer: attempting double-free on ...
+	headerPattern = regexp.MustCompile(`==\d+==(?:ERROR|WARNING): (\w+Sanitizer): (attempting [\w-]+|[\w-]+)`)
+	// WRITE of size 1 at 0x602000000019 thread T0
+	accessPattern = regexp.MustCompile(`^(READ|WRITE) of size \d+`)
+	// file.c:12:5: runtime error: signed integer overflow: ...
+	runtimeErrorPattern = regexp.MustCompile(`runtime error: ([^:]+)`)
+	//     #0 0x4c5c7c  (/path/to/target+0x4c5c7c)
+	framePattern = regexp.MustCompile(`^\s*#\d+ 0x[0-9a-f]+ .*\(([^()]+)\+(0x[0-9a-f]+)\)`)
+)
+
+// Parse finds the first sanitizer report in stderr, nil when there is none
+func Parse(stderr []byte) *Report {
+	var report *Report
+	scanner := bufio.NewScanner(bytes.NewReader(stderr))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if report == nil {
+			if match := headerPattern.FindStringSubmatch(line); match != nil {
+				report = &Report{Tool: match[1], Type: match[2]}
+			} else if match := runtimeErrorPattern.FindStringSubmatch(line); match != nil {
+				report = &Report{Tool: "UndefinedBehaviorSanitizer", Type: strings.TrimSpace(match[1])}
+			}
+			continue
+		}
+		if match := accessPattern.FindStringSubmatch(line); match != nil && len(report.Frames) == 0 {
+			report.Access = match[1]
+		} else if match := framePattern.FindStringSubmatch(line); match != nil {
+			report.Frames = append(report.Frames, filepath.Base(match[1])+"+"+match[2])
+		} else if len(report.Frames) > 0 {
+			// the first stack is the one of the error, later ones are allocation and free sites
+			break
+		}
+	}
+	return report
+}
+
+// Bucket names the error by its type and the top of its stack
+func (r *Report) Bucket() string {
+	frames := r.Frames[:min(len(r.Frames), bucketFrames)]
+	if len(frames) == 0 {
+		return fmt.Sprintf("%s %s", r.Tool, r.Type)
+	}
+	return fmt.Sprintf("%s %s at %s", r.Tool, r.Type, strings.Join(frames, " "))
+}
+
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", r.Tool, r.Type)
+	if r.Access != "" {
+		fmt.Fprintf(&b, " %s", r.Access)
+	}
+	b.WriteString("\n")
+	for i, frame := range r.Frames {
+		fmt.Fprintf(&b, "  #%d %s\n", i, frame)
+	}
+	return b.String()
+}
@@ -0,0 +1,126 @@
+package sanitizer
+
+import (
+	"slices"
+	"testing"
+)
+
+const heapOverflow = `=================================================================
+==14248==ERROR: AddressSanitizer: heap-buffer-overflow on address 0x602000000019 at pc 0x0000004013fb bp 0x7ffc4d709d10 sp 0x7ffc4d709d08
+WRITE of size 1 at 0x602000000019 thread T0
+    #0 0x4013fa  (/tmp/asan+0x4013fa)
+    #1 0x7f430a045249  (/lib/x86_64-linux-gnu/libc.so.6+0x27249)
+    #2 0x7f430a045304  (/lib/x86_64-linux-gnu/libc.so.6+0x27304)
+    #3 0x401130  (/tmp/asan+0x401130)
+
+0x602000000019 is located 1 bytes to the right of 8-byte region [0x602000000010,0x602000000018)
+allocated by thread T0 here:
+    #0 0x7f430a2b89cf  (/lib/x86_64-linux-gnu/libasan.so.8+0xb89cf)
+    #1 0x40132a  (/tmp/asan+0x40132a)
+    #2 0x7f430a045249  (/lib/x86_64-linux-gnu/libc.so.6+0x27249)
+
+SUMMARY: AddressSanitizer: heap-buffer-overflow (/tmp/asan+0x4013fa)
+`
+
+const useAfterFree = `ok 3
+=================================================================
+==2051==ERROR: AddressSanitizer: heap-use-after-free on address 0x603000000010 at pc 0x000000401236 bp 0x7ffd1a2b3c40 sp 0x7ffd1a2b3c38
+READ of size 4 at 0x603000000010 thread T0
+    #0 0x401235  (/work/parser+0x401235)
+    #1 0x4012a0  (/work/parser+0x4012a0)
+
+0x603000000010 is located 0 bytes inside of 24-byte region [0x603000000010,0x603000000028)
+freed by thread T0 here:
+    #0 0x7f2b4c8d7a98  (/lib/x86_64-linux-gnu/libasan.so.8+0xd7a98)
+    #1 0x401200  (/work/parser+0x401200)
+
+previously allocated by thread T0 here:
+    #0 0x7f2b4c8d89cf  (/lib/x86_64-linux-gnu/libasan.so.8+0xd89cf)
+    #1 0x4011c0  (/work/parser+0x4011c0)
+`
+
+const doubleFree = `==77==ERROR: AddressSanitizer: attempting double-free on 0x602000000010 in thread T0:
+    #0 0x7f9e6a8d7a98  (/lib/x86_64-linux-gnu/libasan.so.8+0xd7a98)
+    #1 0x401190  (/work/parser+0x401190)
+
+0x602000000010 is located 0 bytes inside of 8-byte region [0x602000000010,0x602000000018)
+freed by thread T0 here:
+    #0 0x7f9e6a8d7a98  (/lib/x86_64-linux-gnu/libasan.so.8+0xd7a98)
+    #1 0x401180  (/work/parser+0x401180)
+`
+
+const runtimeError = `parse.c:41:17: runtime error: signed integer overflow: 2147483647 + 1 cannot be represented in type 'int'
+`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   *Report
+		bucket string
+	}{
+		{
+			name:   "heap buffer overflow",
+			stderr: heapOverflow,
+			want: &Report{
+				Tool:   "AddressSanitizer",
+				Type:   "heap-buffer-overflow",
+				Access: "WRITE",
+				Frames: []string{"asan+0x4013fa", "libc.so.6+0x27249", "libc.so.6+0x27304", "asan+0x401130"},
+			},
+			bucket: "AddressSanitizer heap-buffer-overflow at asan+0x4013fa libc.so.6+0x27249 libc.so.6+0x27304",
+		},
+		{
+			// the free and allocation stacks after the error's own are left out
+			name:   "use after free keeps the first stack",
+			stderr: useAfterFree,
+			want: &Report{
+				Tool:   "AddressSanitizer",
+				Type:   "heap-use-after-free",
+				Access: "READ",
+				Frames: []string{"parser+0x401235", "parser+0x4012a0"},
+			},
+			bucket: "AddressSanitizer heap-use-after-free at parser+0x401235 parser+0x4012a0",
+		},
+		{
+			name:   "double free",
+			stderr: doubleFree,
+			want: &Report{
+				Tool:   "AddressSanitizer",
+				Type:   "attempting double-free",
+				Frames: []string{"libasan.so.8+0xd7a98", "parser+0x401190"},
+			},
+			bucket: "AddressSanitizer attempting double-free at libasan.so.8+0xd7a98 parser+0x401190",
+		},
+		{
+			name:   "runtime error without a stack",
+			stderr: runtimeError,
+			want:   &Report{Tool: "UndefinedBehaviorSanitizer", Type: "signed integer overflow"},
+			bucket: "UndefinedBehaviorSanitizer signed integer overflow",
+		},
+		{
+			name:   "no report",
+			stderr: "usage: parser <file>\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Parse([]byte(test.stderr))
+			if test.want == nil {
+				if got != nil {
+					t.Fatalf("Parse = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("Parse = nil")
+			}
+			if got.Tool != test.want.Tool || got.Type != test.want.Type || got.Access != test.want.Access || !slices.Equal(got.Frames, test.want.Frames) {
+				t.Errorf("Parse = %+v, want %+v", got, test.want)
+			}
+			if bucket := got.Bucket(); bucket != test.bucket {
+				t.Errorf("Bucket = %q, want %q", bucket, test.bucket)
+			}
+		})
+	}
+}
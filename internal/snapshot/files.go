@@ -0,0 +1,148 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type FileDescriptor struct {
+	Fd     int
+	Path   string
+	Offset int64
+	Flags  int
+}
+
+// Regular files are the only descriptors we can seek or reopen, sockets pipes and
+// anon inodes show up as "socket:[ino]" style links
+func (f FileDescriptor) IsRegular() bool {
+	return strings.HasPrefix(f.Path, "/")
+}
+
+func GetFilesFromProcess(pid int) ([]FileDescriptor, error) {
+	files := make([]FileDescriptor, 0)
+	fdDir := fmt.Sprintf("/proc/%d/fd", pid)
+	entries, err := os.ReadDir(fdDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		fd, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		link, err := os.Readlink(fmt.Sprintf("%s/%s", fdDir, e.Name()))
+		if err != nil {
+			// fd was closed while we were reading the directory
+			continue
+		}
+		file := FileDescriptor{Fd: fd, Path: link}
+		file.Offset, file.Flags = parseFdInfo(pid, fd)
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Fd < files[j].Fd })
+	return files, nil
+}
+
+func parseFdInfo(pid int, fd int) (int64, int) {
+	var offset int64
+	var flags int
+	raw, err := os.ReadFile(fmt.Sprintf("/proc/%d/fdinfo/%d", pid, fd))
+	if err != nil {
+		return 0, 0
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "pos":
+			offset, _ = strconv.ParseInt(value, 10, 64)
+		case "flags":
+			// flags are printed in octal
+			f, _ := strconv.ParseInt(value, 8, 64)
+			flags = int(f)
+		}
+	}
+	return offset, flags
+}
+
+// RestoreFiles puts the fd table of the tracee back into the state recorded in the snapshot
+// Fds opened after the snapshot are closed, regular files have their offsets reset and
+// files that were closed or replaced since the snapshot are reopened at the same fd number
+func RestoreFiles(pid int, saved []FileDescriptor) error {
+	files, err := GetFilesFromProcess(pid)
+	if err != nil {
+		return err
+	}
+	current := make(map[int]FileDescriptor)
+	for _, f := range files {
+		current[f.Fd] = f
+	}
+	wanted := make(map[int]bool)
+	for _, f := range saved {
+		wanted[f.Fd] = true
+	}
+	for fd := range current {
+		if !wanted[fd] {
+			if _, err := InjectSyscall(pid, syscall.SYS_CLOSE, uint64(fd)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range saved {
+		cur, ok := current[f.Fd]
+		if !ok || cur.Path != f.Path {
+			if !f.IsRegular() {
+				fmt.Fprintf(os.Stderr, "WARNING: cannot reopen fd %d (%s)\n", f.Fd, f.Path)
+				continue
+			}
+			if err := reopenFile(pid, f); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.IsRegular() && cur.Offset != f.Offset {
+			ret, err := InjectSyscall(pid, syscall.SYS_LSEEK, uint64(f.Fd), uint64(f.Offset), 0)
+			if err != nil {
+				return err
+			}
+			if ret < 0 {
+				fmt.Fprintf(os.Stderr, "WARNING: lseek fd %d failed %d\n", f.Fd, ret)
+			}
+		}
+	}
+	return nil
+}
+
+func reopenFile(pid int, f FileDescriptor) error {
+	// Never recreate or truncate files we are putting back
+	flags := f.Flags &^ (syscall.O_CREAT | syscall.O_TRUNC | syscall.O_EXCL)
+	pathAddr, err := WriteScratchString(pid, f.Path)
+	if err != nil {
+		return err
+	}
+	newFd, err := InjectSyscall(pid, syscall.SYS_OPENAT, AtFdCwd, pathAddr, uint64(flags), 0)
+	if err != nil {
+		return err
+	}
+	if newFd < 0 {
+		fmt.Fprintf(os.Stderr, "WARNING: reopen of %s failed %d\n", f.Path, newFd)
+		return nil
+	}
+	if int(newFd) != f.Fd {
+		if _, err := InjectSyscall(pid, syscall.SYS_DUP2, uint64(newFd), uint64(f.Fd)); err != nil {
+			return err
+		}
+		if _, err := InjectSyscall(pid, syscall.SYS_CLOSE, uint64(newFd)); err != nil {
+			return err
+		}
+	}
+	_, err = InjectSyscall(pid, syscall.SYS_LSEEK, uint64(f.Fd), uint64(f.Offset), 0)
+	return err
+}
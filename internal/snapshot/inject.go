@@ -0,0 +1,74 @@
+package snapshot
+
+import (
+	"fmt"
+	"matcha/fuzzer/ptrace"
+)
+
+// AtFdCwd is AT_FDCWD (-100) as a syscall argument
+const AtFdCwd = ^uint64(99)
+
+// Scratch space used to pass strings to injected syscalls, kept below the red zone
+const scratchOffset = 0x1000
+
+// InjectSyscall runs a single syscall inside the stopped tracee by writing a syscall
+// instruction at the current pc and single stepping over it, the registers and the
+// original instruction bytes are put back afterwards. Returns rax so -errno when the
+// syscall failed, err is only set when the tracee could not be driven
+func InjectSyscall(pid int, number uint64, args ...uint64) (int64, error) {
+	saved, err := ptrace.GetRegs(pid)
+	if err != nil {
+		return 0, err
+	}
+	pc := saved.Rip
+	original := make([]byte, 2)
+	if err := ptrace.ReadMemory(pid, pc, original); err != nil {
+		return 0, err
+	}
+	if err := ptrace.WriteMemory(pid, pc, []byte{0x0f, 0x05}); err != nil {
+		return 0, err
+	}
+	regs := saved
+	regs.Rax = number
+	// not stopped inside a syscall so the kernel must not try to restart one
+	regs.Orig_rax = ^uint64(0)
+	argRegs := []*uint64{&regs.Rdi, &regs.Rsi, &regs.Rdx, &regs.R10, &regs.R8, &regs.R9}
+	for i, arg := range args {
+		*argRegs[i] = arg
+	}
+	if err := ptrace.SetRegs(pid, regs); err != nil {
+		return 0, err
+	}
+	if err := ptrace.SingleStep(pid); err != nil {
+		return 0, err
+	}
+	ws, err := ptrace.Wait(pid)
+	if err != nil {
+		return 0, err
+	}
+	if ws.Exited() || ws.Signaled() {
+		return 0, fmt.Errorf("injected syscall %d: %w", number, ptrace.ErrExited)
+	}
+	if regs, err = ptrace.GetRegs(pid); err != nil {
+		return 0, err
+	}
+	if err := ptrace.WriteMemory(pid, pc, original); err != nil {
+		return 0, err
+	}
+	if err := ptrace.SetRegs(pid, saved); err != nil {
+		return 0, err
+	}
+	return int64(regs.Rax), nil
+}
+
+// WriteScratchString places a nul terminated string on the tracee stack below the red zone
+// and returns its address, only valid until the tracee runs again
+func WriteScratchString(pid int, str string) (uint64, error) {
+	regs, err := ptrace.GetRegs(pid)
+	if err != nil {
+		return 0, err
+	}
+	data := append([]byte(str), 0)
+	address := (regs.Rsp - scratchOffset - uint64(len(data))) &^ 0xf
+	return address, ptrace.WriteMemory(pid, address, data)
+}
@@ -0,0 +1,97 @@
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const pageSize = 0x1000
+
+func (s *Snapshot) Size() uint64 {
+	var total uint64
+	for _, region := range s.Memory {
+		total += region.End - region.Start
+	}
+	return total
+}
+
+// Diff returns a human readable list of differences between two snapshots, memory is
+// compared page by page for regions that exist at the same address in both
+func Diff(a Snapshot, b Snapshot) []string {
+	diffs := make([]string, 0)
+	regsA := Registers(a.Registers)
+	regsB := Registers(b.Registers)
+	for i := range regsA {
+		if regsA[i].Value != regsB[i].Value {
+			diffs = append(diffs, fmt.Sprintf("register %s 0x%x -> 0x%x", regsA[i].Name, regsA[i].Value, regsB[i].Value))
+		}
+	}
+	regionsB := make(map[uint64]MemoryRegion)
+	for _, region := range b.Memory {
+		regionsB[region.Start] = region
+	}
+	for _, ra := range a.Memory {
+		rb, ok := regionsB[ra.Start]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("region 0x%x-0x%x %s only in first", ra.Start, ra.End, ra.Name))
+			continue
+		}
+		delete(regionsB, ra.Start)
+		if ra.End != rb.End {
+			diffs = append(diffs, fmt.Sprintf("region 0x%x %s size 0x%x -> 0x%x", ra.Start, ra.Name, ra.End-ra.Start, rb.End-rb.Start))
+		}
+		common := len(ra.RawData)
+		if len(rb.RawData) < common {
+			common = len(rb.RawData)
+		}
+		for off := 0; off < common; off += pageSize {
+			end := off + pageSize
+			if end > common {
+				end = common
+			}
+			pa := ra.RawData[off:end]
+			pb := rb.RawData[off:end]
+			if bytes.Equal(pa, pb) {
+				continue
+			}
+			changed := 0
+			first := -1
+			for i := range pa {
+				if pa[i] != pb[i] {
+					changed++
+					if first == -1 {
+						first = i
+					}
+				}
+			}
+			diffs = append(diffs, fmt.Sprintf("memory 0x%x %s %d bytes differ in page, first at 0x%x", ra.Start+uint64(off), ra.Name, changed, ra.Start+uint64(off+first)))
+		}
+	}
+	for _, region := range b.Memory {
+		if _, ok := regionsB[region.Start]; ok {
+			diffs = append(diffs, fmt.Sprintf("region 0x%x-0x%x %s only in second", region.Start, region.End, region.Name))
+		}
+	}
+	filesB := make(map[int]FileDescriptor)
+	for _, f := range b.Files {
+		filesB[f.Fd] = f
+	}
+	for _, fa := range a.Files {
+		fb, ok := filesB[fa.Fd]
+		delete(filesB, fa.Fd)
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("fd %d %s only in first", fa.Fd, fa.Path))
+		case fa.Path != fb.Path:
+			diffs = append(diffs, fmt.Sprintf("fd %d %s -> %s", fa.Fd, fa.Path, fb.Path))
+		case fa.Offset != fb.Offset:
+			diffs = append(diffs, fmt.Sprintf("fd %d %s offset %d -> %d", fa.Fd, fa.Path, fa.Offset, fb.Offset))
+		}
+	}
+	for _, f := range b.Files {
+		if _, ok := filesB[f.Fd]; ok {
+			diffs = append(diffs, fmt.Sprintf("fd %d %s only in second", f.Fd, f.Path))
+		}
+	}
+	return diffs
+}
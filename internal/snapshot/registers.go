@@ -0,0 +1,34 @@
+package snapshot
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"syscall"
+)
+
+type Register struct {
+	Name  string
+	Value uint64
+}
+
+func Registers(regs syscall.PtraceRegs) []Register {
+	out := make([]Register, 0)
+	v := reflect.ValueOf(regs)
+	for i := 0; i < v.NumField(); i++ {
+		out = append(out, Register{Name: v.Type().Field(i).Name, Value: v.Field(i).Uint()})
+	}
+	return out
+}
+
+// RegisterByName returns a pointer to a register in regs by its name, case insensitive
+// so "rdi" and "Rdi" both work
+func RegisterByName(regs *syscall.PtraceRegs, name string) (*uint64, error) {
+	v := reflect.ValueOf(regs).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if strings.EqualFold(v.Type().Field(i).Name, name) {
+			return v.Field(i).Addr().Interface().(*uint64), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown register %s", name)
+}
@@ -0,0 +1,81 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// MapRegions makes sure every region of a loaded snapshot is mapped in a freshly spawned
+// tracee so it can be written back with WriteRegionToProcess. The heap is grown with brk
+// so malloc keeps working, anything else that is missing gets a fixed anonymous mapping.
+// Only makes sense when the tracee was started with ASLR disabled
+func MapRegions(pid int, regions []MemoryRegion) error {
+	for _, region := range regions {
+		current, err := currentRanges(pid)
+		if err != nil {
+			return err
+		}
+		if region.Name == "[heap]" {
+			ret, err := InjectSyscall(pid, syscall.SYS_BRK, region.End)
+			if err != nil {
+				return err
+			}
+			if uint64(ret) < region.End {
+				fmt.Fprintf(os.Stderr, "WARNING: brk to 0x%x failed, mapping heap instead\n", region.End)
+			} else {
+				continue
+			}
+		}
+		for _, missing := range missingRanges(region.Start, region.End, current) {
+			ret, err := InjectSyscall(pid, syscall.SYS_MMAP, missing[0], missing[1]-missing[0],
+				syscall.PROT_READ|syscall.PROT_WRITE,
+				syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS|syscall.MAP_FIXED, ^uint64(0), 0)
+			if err != nil {
+				return err
+			}
+			if uint64(ret) != missing[0] {
+				return fmt.Errorf("failed to map 0x%x-0x%x %s (%d)", missing[0], missing[1], region.Name, ret)
+			}
+		}
+	}
+	return nil
+}
+
+func currentRanges(pid int) ([][2]uint64, error) {
+	ranges := make([][2]uint64, 0)
+	rawMaps, err := os.ReadFile(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, err
+	}
+	var start, end uint64
+	for _, line := range strings.Split(string(rawMaps), "\n") {
+		if _, err := fmt.Sscanf(line, "%x-%x", &start, &end); err == nil {
+			ranges = append(ranges, [2]uint64{start, end})
+		}
+	}
+	return ranges, nil
+}
+
+// missingRanges returns the parts of start-end not covered by the sorted ranges
+func missingRanges(start uint64, end uint64, ranges [][2]uint64) [][2]uint64 {
+	missing := make([][2]uint64, 0)
+	cursor := start
+	for _, r := range ranges {
+		if r[1] <= cursor || r[0] >= end {
+			continue
+		}
+		if r[0] > cursor {
+			missing = append(missing, [2]uint64{cursor, r[0]})
+		}
+		cursor = r[1]
+		if cursor >= end {
+			break
+		}
+	}
+	if cursor < end {
+		missing = append(missing, [2]uint64{cursor, end})
+	}
+	return missing
+}
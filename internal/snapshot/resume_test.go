@@ -0,0 +1,33 @@
+package snapshot
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMissingRanges(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end uint64
+		ranges     [][2]uint64
+		want       [][2]uint64
+	}{
+		{"nothing mapped", 0x1000, 0x3000, nil, [][2]uint64{{0x1000, 0x3000}}},
+		{"exactly mapped", 0x1000, 0x3000, [][2]uint64{{0x1000, 0x3000}}, nil},
+		{"empty span", 0x1000, 0x1000, [][2]uint64{{0x4000, 0x5000}}, nil},
+		{"gap in the middle", 0x1000, 0x4000, [][2]uint64{{0x1000, 0x2000}, {0x3000, 0x4000}}, [][2]uint64{{0x2000, 0x3000}}},
+		{"mapped from before the start", 0x2000, 0x4000, [][2]uint64{{0x1000, 0x3000}}, [][2]uint64{{0x3000, 0x4000}}},
+		{"mapped past the end", 0x1000, 0x3000, [][2]uint64{{0x2000, 0x5000}}, [][2]uint64{{0x1000, 0x2000}}},
+		{"ranges outside", 0x2000, 0x3000, [][2]uint64{{0x1000, 0x2000}, {0x3000, 0x4000}}, [][2]uint64{{0x2000, 0x3000}}},
+		{"overlapping ranges", 0x1000, 0x6000, [][2]uint64{{0x1000, 0x3000}, {0x2000, 0x4000}, {0x2800, 0x3800}}, [][2]uint64{{0x4000, 0x6000}}},
+		{"range inside an earlier one", 0x1000, 0x5000, [][2]uint64{{0x2000, 0x4000}, {0x2800, 0x3000}}, [][2]uint64{{0x1000, 0x2000}, {0x4000, 0x5000}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := missingRanges(test.start, test.end, test.ranges)
+			if !slices.Equal(got, test.want) {
+				t.Errorf("missingRanges = %x, want %x", got, test.want)
+			}
+		})
+	}
+}
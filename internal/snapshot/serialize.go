@@ -0,0 +1,232 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// On disk layout, everything little endian
+//
+//	magic "MTCHSNAP" | version u32 | pid i64 | registers (PtraceRegs)
+//	region count u32 | { start u64 | end u64 | name | zlib size u32 | zlib page data }
+//	fd count u32     | { fd i32 | flags i32 | offset i64 | path }
+//
+// strings are a u16 length followed by the bytes
+const (
+	fileMagic   = "MTCHSNAP"
+	fileVersion = 1
+)
+
+// bounds on what a snapshot file may ask Load to allocate, far above vm.max_map_count, the
+// default fd limit and any writable mapping a target is snapshotted with
+const (
+	maxRegions    = 1 << 20
+	maxFiles      = 1 << 20
+	maxRegionSize = 1 << 36
+)
+
+var ErrBadSnapshotFile = errors.New("not a matcha snapshot file")
+
+func (s *Snapshot) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	le := binary.LittleEndian
+	w.WriteString(fileMagic)
+	binary.Write(w, le, uint32(fileVersion))
+	binary.Write(w, le, int64(s.Pid))
+	if err := binary.Write(w, le, s.Registers); err != nil {
+		return err
+	}
+	binary.Write(w, le, uint32(len(s.Memory)))
+	for _, region := range s.Memory {
+		binary.Write(w, le, region.Start)
+		binary.Write(w, le, region.End)
+		writeString(w, region.Name)
+		compressed, err := compress(region.RawData)
+		if err != nil {
+			return err
+		}
+		binary.Write(w, le, uint32(len(compressed)))
+		w.Write(compressed)
+	}
+	binary.Write(w, le, uint32(len(s.Files)))
+	for _, file := range s.Files {
+		binary.Write(w, le, int32(file.Fd))
+		binary.Write(w, le, int32(file.Flags))
+		binary.Write(w, le, file.Offset)
+		writeString(w, file.Path)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func Load(path string) (Snapshot, error) {
+	var snap Snapshot
+	f, err := os.Open(path)
+	if err != nil {
+		return snap, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(fileMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != fileMagic {
+		return snap, ErrBadSnapshotFile
+	}
+	var version uint32
+	var pid int64
+	if err := read(r, &version); err != nil {
+		return snap, err
+	}
+	if version != fileVersion {
+		return snap, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	if err := read(r, &pid); err != nil {
+		return snap, err
+	}
+	snap.Pid = int(pid)
+	if err := read(r, &snap.Registers); err != nil {
+		return snap, err
+	}
+	var count uint32
+	if err := read(r, &count); err != nil {
+		return snap, err
+	}
+	if count > maxRegions {
+		return snap, fmt.Errorf("%w: %d regions", ErrBadSnapshotFile, count)
+	}
+	snap.Memory = make([]MemoryRegion, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var region MemoryRegion
+		var size uint32
+		if err := read(r, &region.Start); err != nil {
+			return snap, err
+		}
+		if err := read(r, &region.End); err != nil {
+			return snap, err
+		}
+		if region.End <= region.Start || region.End-region.Start > maxRegionSize {
+			return snap, fmt.Errorf("%w: region 0x%x-0x%x", ErrBadSnapshotFile, region.Start, region.End)
+		}
+		if region.Name, err = readString(r); err != nil {
+			return snap, err
+		}
+		if err := read(r, &size); err != nil {
+			return snap, err
+		}
+		if uint64(size) > maxCompressedSize(region.End-region.Start) {
+			return snap, fmt.Errorf("%w: region 0x%x-0x%x compressed to %d bytes", ErrBadSnapshotFile, region.Start, region.End, size)
+		}
+		compressed := make([]byte, size)
+		if _, err := io.ReadFull(r, compressed); err != nil {
+			return snap, badFile(err)
+		}
+		region.RawData, err = decompress(compressed, region.End-region.Start)
+		if err != nil {
+			return snap, fmt.Errorf("%w: region 0x%x-0x%x: %v", ErrBadSnapshotFile, region.Start, region.End, err)
+		}
+		snap.Memory = append(snap.Memory, region)
+	}
+	if err := read(r, &count); err != nil {
+		return snap, err
+	}
+	if count > maxFiles {
+		return snap, fmt.Errorf("%w: %d fds", ErrBadSnapshotFile, count)
+	}
+	snap.Files = make([]FileDescriptor, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var fd, flags int32
+		var file FileDescriptor
+		if err := read(r, &fd); err != nil {
+			return snap, err
+		}
+		if err := read(r, &flags); err != nil {
+			return snap, err
+		}
+		if err := read(r, &file.Offset); err != nil {
+			return snap, err
+		}
+		if file.Path, err = readString(r); err != nil {
+			return snap, err
+		}
+		file.Fd = int(fd)
+		file.Flags = int(flags)
+		snap.Files = append(snap.Files, file)
+	}
+	return snap, nil
+}
+
+// read decodes the next little endian field
+func read(r io.Reader, data any) error {
+	if err := binary.Read(r, binary.LittleEndian, data); err != nil {
+		return badFile(err)
+	}
+	return nil
+}
+
+// badFile reports a file that ends in the middle of a field as a bad snapshot file
+func badFile(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: truncated", ErrBadSnapshotFile)
+	}
+	return err
+}
+
+// maxCompressedSize is the most zlib can grow size bytes of incompressible data by, with
+// room to spare
+func maxCompressedSize(size uint64) uint64 {
+	return size + size/1000 + 1024
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(data []byte, size uint64) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	out := make([]byte, size)
+	if _, err := io.ReadFull(zr, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func writeString(w *bufio.Writer, str string) {
+	binary.Write(w, binary.LittleEndian, uint16(len(str)))
+	w.WriteString(str)
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	var length uint16
+	if err := read(r, &length); err != nil {
+		return "", err
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", badFile(err)
+	}
+	return string(buf), nil
+}
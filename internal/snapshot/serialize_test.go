@@ -0,0 +1,100 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func testSnapshot() Snapshot {
+	stack := make([]byte, 0x2000)
+	for i := range stack {
+		stack[i] = byte(i * 7)
+	}
+	return Snapshot{
+		Pid:       4242,
+		Registers: syscall.PtraceRegs{Rip: 0x401166, Rsp: 0x7ffffffde000, Rdi: 0x7ffffffde100, Rax: 0xdeadbeef, Fs_base: 0x7ffff7d80740},
+		Memory: []MemoryRegion{
+			{Start: 0x404000, End: 0x405000, Name: "/bin/target", RawData: bytes.Repeat([]byte{0x41}, 0x1000)},
+			{Start: 0x7ffffffdd000, End: 0x7ffffffdf000, Name: "[stack]", RawData: stack},
+		},
+		Files: []FileDescriptor{
+			{Fd: 0, Path: "/dev/pts/0", Flags: syscall.O_RDWR},
+			{Fd: 3, Path: "/tmp/input", Offset: 64, Flags: syscall.O_RDONLY},
+			{Fd: 4, Path: "socket:[1234]", Flags: syscall.O_RDWR},
+		},
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "target.snap")
+	snap := testSnapshot()
+	if err := snap.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Pid != snap.Pid {
+		t.Errorf("Pid = %d, want %d", loaded.Pid, snap.Pid)
+	}
+	if loaded.Registers != snap.Registers {
+		t.Errorf("Registers = %+v, want %+v", loaded.Registers, snap.Registers)
+	}
+	if !reflect.DeepEqual(loaded.Memory, snap.Memory) {
+		t.Errorf("Memory differs after a round trip")
+	}
+	if !reflect.DeepEqual(loaded.Files, snap.Files) {
+		t.Errorf("Files = %+v, want %+v", loaded.Files, snap.Files)
+	}
+}
+
+func TestLoadBadFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "target.snap")
+	snap := testSnapshot()
+	if err := snap.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first region starts right after the header and the region count
+	regionOffset := len(fileMagic) + 4 + 8 + binary.Size(syscall.PtraceRegs{}) + 4
+	inverted := bytes.Clone(data)
+	binary.LittleEndian.PutUint64(inverted[regionOffset+8:], snap.Memory[0].Start)
+	huge := bytes.Clone(data)
+	binary.LittleEndian.PutUint64(huge[regionOffset+8:], snap.Memory[0].Start+maxRegionSize+1)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"wrong magic", []byte("NOTASNAPSHOT")},
+		{"truncated header", data[:len(fileMagic)+2]},
+		{"truncated registers", data[:regionOffset-10]},
+		{"truncated region", data[:regionOffset+20]},
+		{"truncated page data", data[:len(data)/2]},
+		{"truncated fds", data[:len(data)-3]},
+		{"region end before start", inverted},
+		{"region too big", huge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bad := filepath.Join(dir, "bad.snap")
+			if err := os.WriteFile(bad, test.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(bad); !errors.Is(err, ErrBadSnapshotFile) {
+				t.Errorf("Load = %v, want %v", err, ErrBadSnapshotFile)
+			}
+		})
+	}
+}
@@ -2,8 +2,9 @@ package snapshot
 
 import (
 	"fmt"
-	"log"
+	"matcha/fuzzer/ptrace"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -13,6 +14,7 @@ type Snapshot struct {
 	Pid       int
 	Registers syscall.PtraceRegs
 	Memory    []MemoryRegion
+	Files     []FileDescriptor
 }
 
 type MemoryRegion struct {
@@ -22,101 +24,78 @@ type MemoryRegion struct {
 	RawData []byte
 }
 
-func NewSnapshot(pid int) Snapshot {
-	snap := Snapshot{
-		Pid:       pid,
-		Registers: syscall.PtraceRegs{},
-		Memory:    GetRegionsFromProcess(pid),
+func NewSnapshot(pid int) (Snapshot, error) {
+	snap := Snapshot{Pid: pid}
+	var err error
+	if snap.Memory, err = GetRegionsFromProcess(pid); err != nil {
+		return snap, err
 	}
-	syscall.PtraceGetRegs(pid, &snap.Registers)
-	return snap
+	if snap.Files, err = GetFilesFromProcess(pid); err != nil {
+		return snap, err
+	}
+	snap.Registers, err = ptrace.GetRegs(pid)
+	return snap, err
 }
 
-func ParseRegion(pid int, data string) MemoryRegion {
+func ParseRegion(pid int, data string) (MemoryRegion, error) {
 	sections := strings.Split(data, " ")
 	startEnd := strings.Split(sections[0], "-")
 	name := sections[len(sections)-1]
 	if name == "" {
 		name = "Anonymous"
 	}
+	if len(startEnd) != 2 {
+		return MemoryRegion{}, fmt.Errorf("bad maps line %q", data)
+	}
 	start, err := strconv.ParseUint(startEnd[0], 16, 64)
 	if err != nil {
-		log.Fatal(err)
+		return MemoryRegion{}, err
 	}
 	end, err := strconv.ParseUint(startEnd[1], 16, 64)
 	if err != nil {
-		log.Fatal(err)
+		return MemoryRegion{}, err
 	}
 	return NewRegion(pid, start, end, name)
 }
 
-func NewRegion(pid int, start uint64, end uint64, name string) MemoryRegion {
+func NewRegion(pid int, start uint64, end uint64, name string) (MemoryRegion, error) {
 	region := MemoryRegion{
 		Start: start,
 		End:   end,
 		Name:  name,
 	}
-	path := fmt.Sprintf("/proc/%d/mem", pid)
-	memPtr, err := os.Open(path)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer memPtr.Close()
-	_, err = memPtr.Seek(int64(region.Start), 0)
-	if err != nil {
-		log.Fatal(err)
-	}
-	buffer := make([]byte, end-start)
-	_, err = memPtr.Read(buffer)
-	if err != nil {
-		log.Fatal(err)
-	}
-	region.RawData = buffer
-	return region
+	var err error
+	region.RawData, err = ReadRegionFromProcess(pid, start, end)
+	return region, err
 }
 
-func ReadRegionFromProcess(pid int, start uint64, end uint64) []byte {
+func ReadRegionFromProcess(pid int, start uint64, end uint64) ([]byte, error) {
 	path := fmt.Sprintf("/proc/%d/mem", pid)
-	memPtr, err := os.OpenFile(path, os.O_RDWR, 0644)
+	memPtr, err := os.Open(path)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer memPtr.Close()
-	_, err = memPtr.Seek(int64(start), 0)
-	if err != nil {
-		log.Fatal(err)
-	}
 	buffer := make([]byte, end-start)
-	_, err = memPtr.Read(buffer)
-	if err != nil {
-		log.Fatal(err)
+	if _, err := memPtr.ReadAt(buffer, int64(start)); err != nil {
+		return nil, fmt.Errorf("read region 0x%x-0x%x: %w", start, end, err)
 	}
-	return buffer
+	return buffer, nil
 }
 
-func WriteRegionToProcess(pid int, region MemoryRegion) {
-	path := fmt.Sprintf("/proc/%d/mem", pid)
-	memPtr, err := os.OpenFile(path, os.O_RDWR, 0644)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer memPtr.Close()
-	_, err = memPtr.Seek(int64(region.Start), 0)
-	if err != nil {
-		log.Fatal(err)
-	}
-	_, err = memPtr.Write(region.RawData)
-	if err != nil {
-		log.Fatal(err)
+func WriteRegionToProcess(pid int, region MemoryRegion) error {
+	if err := ptrace.WriteMemory(pid, region.Start, region.RawData); err != nil {
+		return fmt.Errorf("write region %s: %w", region.Name, err)
 	}
+	return nil
 }
 
-func GetRegionsFromProcess(pid int) []MemoryRegion {
+func GetRegionsFromProcess(pid int) ([]MemoryRegion, error) {
 	regions := make([]MemoryRegion, 0)
 	path := fmt.Sprintf("/proc/%d/maps", pid)
 	rawMaps, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	// Only want writable memory regions that could have changed during execution
 	segments := strings.Split(string(rawMaps), "\n")
@@ -126,29 +105,26 @@ func GetRegionsFromProcess(pid int) []MemoryRegion {
 		}
 		entry := strings.Split(s, " ")
 		if strings.Contains(entry[1], "rw") {
-			regions = append(regions, ParseRegion(pid, s))
+			region, err := ParseRegion(pid, s)
+			if err != nil {
+				return nil, err
+			}
+			regions = append(regions, region)
 		}
 	}
-	return regions
+	return regions, nil
 }
 
-func MemoryDump(pid int) {
-	path := fmt.Sprintf("/proc/%d/maps", pid)
-	rawMaps, err := os.ReadFile(path)
+func MemoryDump(pid int) error {
+	regions, err := GetRegionsFromProcess(pid)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	// Only want writable memory regions that could have changed during execution
-	segments := strings.Split(string(rawMaps), "\n")
-	for i, s := range segments {
-		if len(s) < 1 {
-			continue
-		}
-		entry := strings.Split(s, " ")
-		if strings.Contains(entry[1], "rw") {
-			reg := ParseRegion(pid, s)
-			name := fmt.Sprintf("%d_%s.dump", i, reg.Name)
-			os.WriteFile(name, reg.RawData, 0644)
+	for i, reg := range regions {
+		name := fmt.Sprintf("%d_%s.dump", i, filepath.Base(reg.Name))
+		if err := os.WriteFile(name, reg.RawData, 0644); err != nil {
+			return err
 		}
 	}
+	return nil
 }
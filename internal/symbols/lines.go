@@ -0,0 +1,146 @@
+package symbols
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Line is a row of the DWARF line table, the code from Address up to the next row belongs
+// to File:Line. Rows ending a sequence and rows of code no source line is attributed to,
+// DWARF's line 0, have an empty File
+type Line struct {
+	Address uint64
+	File    string
+	Line    int
+}
+
+// Function is a function symbol, Address is its link time address
+type Function struct {
+	Name    string
+	Address uint64
+	Size    uint64
+}
+
+// LineTable reads the line table of every compile unit, sorted by address
+func LineTable(f *elf.File) ([]Line, error) {
+	data, err := f.DWARF()
+	if err != nil {
+		return nil, fmt.Errorf("no DWARF line info: %w", err)
+	}
+	table := make([]Line, 0)
+	reader := data.Reader()
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+		if entry.Tag != dwarf.TagCompileUnit {
+			reader.SkipChildren()
+			continue
+		}
+		lines, err := data.LineReader(entry)
+		if err != nil || lines == nil {
+			continue
+		}
+		var le dwarf.LineEntry
+		for {
+			err := lines.Next(&le)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			line := Line{Address: le.Address}
+			if !le.EndSequence && le.File != nil && le.Line > 0 {
+				line.File = le.File.Name
+				line.Line = le.Line
+			}
+			table = append(table, line)
+		}
+	}
+	if len(table) == 0 {
+		return nil, errors.New("no DWARF line info")
+	}
+	sort.SliceStable(table, func(i, j int) bool {
+		return table[i].Address < table[j].Address
+	})
+	return table, nil
+}
+
+// LinesIn are the rows of a sorted line table for code in [start, end), starting with the
+// row start falls in. Rows without a source line are left out
+func LinesIn(table []Line, start uint64, end uint64) []Line {
+	first := sort.Search(len(table), func(i int) bool {
+		return table[i].Address > start
+	})
+	if first > 0 {
+		first--
+	}
+	lines := make([]Line, 0)
+	for _, line := range table[first:] {
+		if line.Address >= end {
+			break
+		}
+		if line.File != "" && line.Line > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Functions are the function symbols of f with a size, sorted by address
+func Functions(f *elf.File) []Function {
+	syms, _ := f.Symbols()
+	dynSyms, _ := f.DynamicSymbols()
+	seen := make(map[uint64]bool)
+	functions := make([]Function, 0)
+	for _, sym := range append(syms, dynSyms...) {
+		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || sym.Value == 0 || sym.Size == 0 || seen[sym.Value] {
+			continue
+		}
+		seen[sym.Value] = true
+		functions = append(functions, Function{Name: sym.Name, Address: sym.Value, Size: sym.Size})
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		return functions[i].Address < functions[j].Address
+	})
+	return functions
+}
+
+// FunctionAt is the function of a sorted list containing address, nil for none
+func FunctionAt(functions []Function, address uint64) *Function {
+	i := sort.Search(len(functions), func(i int) bool {
+		return functions[i].Address > address
+	})
+	if i == 0 || address >= functions[i-1].Address+functions[i-1].Size {
+		return nil
+	}
+	return &functions[i-1]
+}
+
+// ImageRange is the link time address the first loadable segment starts at, page aligned,
+// and the size of the mapped image. Offsets in a blocks file are from that address
+func ImageRange(f *elf.File) (uint64, uint64) {
+	var start, end uint64
+	found := false
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		if !found || prog.Vaddr < start {
+			start = prog.Vaddr
+		}
+		end = max(end, prog.Vaddr+prog.Memsz)
+		found = true
+	}
+	start &^= 0xfff
+	return start, end - start
+}
@@ -0,0 +1,42 @@
+package symbols
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLinesIn(t *testing.T) {
+	table := []Line{
+		{Address: 0x1000, File: "a.c", Line: 3},
+		{Address: 0x1008, File: "a.c", Line: 4},
+		// compiler generated code without a source line
+		{Address: 0x1010, File: "a.c", Line: 0},
+		{Address: 0x1018, File: "a.c", Line: 7},
+		{Address: 0x1020},
+		{Address: 0x2000, File: "b.c", Line: 1},
+	}
+	tests := []struct {
+		name       string
+		start, end uint64
+		want       []int
+	}{
+		{"one row", 0x1000, 0x1008, []int{3}},
+		{"starts inside a row", 0x1004, 0x100c, []int{3, 4}},
+		{"line 0 is left out", 0x1008, 0x1020, []int{4, 7}},
+		{"only line 0", 0x1010, 0x1018, nil},
+		{"end of sequence", 0x1020, 0x1100, nil},
+		{"before the table", 0x0, 0x1000, nil},
+		{"empty range", 0x1000, 0x1000, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []int
+			for _, line := range LinesIn(table, test.start, test.end) {
+				got = append(got, line.Line)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("LinesIn(0x%x, 0x%x) = %v, want %v", test.start, test.end, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package symbols
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Location is a resolved address spec, when OnReturn is set Address is the entry of a
+// function and the location is wherever that function returns to
+type Location struct {
+	Spec     string
+	Address  uint64
+	OnReturn bool
+}
+
+const returnPrefix = "ret:"
+
+// Resolve turns an address spec into an absolute address in the target. Accepted specs are
+//
+//	0x40B782      raw address
+//	crash         symbol
+//	crash+0x10    symbol plus offset
+//	ret:crash     the return of a function
+//	file.c:123    first statement of a source line from DWARF line info
+//
+// Symbols and lines of PIE binaries are rebased on baseAddress
+func Resolve(path string, baseAddress uint64, spec string) (Location, error) {
+	loc := Location{Spec: spec}
+	if address, err := strconv.ParseUint(spec, 0, 64); err == nil {
+		loc.Address = address
+		return loc, nil
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		return loc, err
+	}
+	defer f.Close()
+	var base uint64
+	if f.Type == elf.ET_DYN {
+		base = baseAddress
+	}
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, returnPrefix) {
+		loc.OnReturn = true
+		spec = strings.TrimPrefix(spec, returnPrefix)
+	}
+	if file, line, found := strings.Cut(spec, ":"); found {
+		lineNumber, err := strconv.Atoi(line)
+		if err != nil {
+			return loc, fmt.Errorf("bad line number in %s", loc.Spec)
+		}
+		address, err := LineAddress(f, file, lineNumber)
+		if err != nil {
+			return loc, err
+		}
+		loc.Address = base + address
+		return loc, nil
+	}
+	name, offsetText, hasOffset := strings.Cut(spec, "+")
+	var offset uint64
+	if hasOffset {
+		offset, err = strconv.ParseUint(offsetText, 0, 64)
+		if err != nil {
+			return loc, fmt.Errorf("bad offset in %s", loc.Spec)
+		}
+	}
+	address, err := SymbolAddress(f, name)
+	if err != nil {
+		return loc, err
+	}
+	loc.Address = base + address + offset
+	return loc, nil
+}
+
+func SymbolAddress(f *elf.File, name string) (uint64, error) {
+	syms, _ := f.Symbols()
+	dynSyms, _ := f.DynamicSymbols()
+	for _, sym := range append(syms, dynSyms...) {
+		if sym.Name == name && sym.Value != 0 {
+			return sym.Value, nil
+		}
+	}
+	return 0, fmt.Errorf("symbol %s not found", name)
+}
+
+// LineAddress returns the lowest statement address generated for file:line, file matches
+// on its base name or as a suffix of the compiled path
+func LineAddress(f *elf.File, file string, line int) (uint64, error) {
+	data, err := f.DWARF()
+	if err != nil {
+		return 0, fmt.Errorf("no DWARF line info: %w", err)
+	}
+	found := false
+	var best uint64
+	reader := data.Reader()
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			return 0, err
+		}
+		if entry == nil {
+			break
+		}
+		if entry.Tag != dwarf.TagCompileUnit {
+			reader.SkipChildren()
+			continue
+		}
+		lines, err := data.LineReader(entry)
+		if err != nil || lines == nil {
+			continue
+		}
+		var le dwarf.LineEntry
+		for {
+			err := lines.Next(&le)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return 0, err
+			}
+			if le.File == nil || le.Line != line || !le.IsStmt || !matchFile(le.File.Name, file) {
+				continue
+			}
+			if !found || le.Address < best {
+				best = le.Address
+				found = true
+			}
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("no code for %s:%d", file, line)
+	}
+	return best, nil
+}
+
+func matchFile(compiled string, want string) bool {
+	if filepath.Base(want) == want {
+		return filepath.Base(compiled) == want
+	}
+	return strings.HasSuffix(compiled, want)
+}